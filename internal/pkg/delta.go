@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/bmatcuk/doublestar/v4"
+	"github.com/google/go-github/v66/github"
 )
 
 // matchPatterns checks if the string matches any of the patterns using filepath.Match.
@@ -73,46 +77,280 @@ func SetGitHubOutput(name, value string) {
 	}
 }
 
-// Delta calculates the difference between the latest successful deployment SHA and the current SHA,
-// and sets GitHub Actions output variables with the results. If there are any changes detected,
-// the "is_detected" output is set to "true" and the "delta_files" output is set to a JSON-encoded
-// list of the changed files. If there are no changes, the "is_detected" output is set to "false".
-func Delta(repoPath string) {
-	cfg := GetInputConfig()
-	cfg.Validate()
-	client := GetClient(&cfg)
+const (
+	// NoBaseSource is the base source reported when the base chain resolved no base and the no_base policy applied
+	NoBaseSource = "no_base"
+)
 
-	var baseSha string
-	var diffs []string
+// DeltaResult holds the outcome of a delta calculation
+type DeltaResult struct {
+	// Base is the base resolved by the base chain
+	Base Base
+	// MergeBase is the merge base the delta was calculated from when merge_base is set
+	MergeBase string
+	// Diverged is set when the base is no longer in the history of the head
+	Diverged bool
+	// DivergedBase is the SHA the delta was calculated from instead of a diverged base
+	DivergedBase string
+	// Head is the SHA the delta was calculated to
+	Head string
+	// Files are the paths of the changed files matching the include and exclude patterns
+	Files []string
+	// Changes are the changes of the files matching the include and exclude patterns
+	Changes []FileChange
+}
+
+// CalculateDelta resolves the base with the chain and calculates the changed files between the base and the
+// current SHA, offline in the repository at repoPath or online with the GitHub API. When merge_base is set,
+// the delta is calculated from the merge base of the resolved base and the current SHA instead.
+func CalculateDelta(client *github.Client, cfg *InputConfig, repoPath string, chain ResolverChain) DeltaResult {
+	var result DeltaResult
+	var diffs []FileChange
 	var err error
-	if cfg.Environment != "" {
-		baseSha = GetLatestSuccessfulDeploymentSha(client, &cfg)
-	} else {
-		baseSha = GetGitHubBranchLatestSHA(client, &cfg)
+
+	result.Base, err = chain.Resolve()
+	if err != nil {
+		log.Panicf("Error resolving the base: %v", err)
+	}
+	baseSha := result.Base.SHA
+
+	// Compare against the head determined together with the base, e.g. the head of a pull request, unless the
+	// head input is given
+	c := *cfg
+	if result.Base.Head != "" && result.Base.Head != c.Sha {
+		if c.Head != "" {
+			log.Printf("Keeping head %s from the head input instead of %s from %s", c.Sha, result.Base.Head, result.Base.Source)
+		} else {
+			log.Printf("Using head %s from %s instead of %s", result.Base.Head, result.Base.Source, c.Sha)
+			c.Sha = result.Base.Head
+		}
+	}
+	result.Head = c.Sha
+
+	// Apply the no_base policy when the chain could not resolve a base
+	if baseSha == "" {
+		baseSha = resolveNoBase(client, &c, repoPath)
+		result.Base = Base{SHA: baseSha, Source: NoBaseSource}
+		if baseSha == "" {
+			return result
+		}
+	}
+
+	// Replace a deployed base that is no longer in the history of the current SHA, e.g. after a force push
+	if IsDivergedSource(&c, result.Base.Source) {
+		result.Diverged, err = IsDivergedBase(client, &c, repoPath, baseSha)
+		if err != nil {
+			log.Panicf("Error checking the base is an ancestor of the current commit: %v", err)
+		}
+		if result.Diverged {
+			baseSha = ResolveDivergedBase(client, &c, repoPath, result.Base)
+			result.DivergedBase = baseSha
+		}
 	}
 
-	if cfg.online == "true" {
-		diffs, err = CompareGithubSHAs(client, &cfg, baseSha)
+	// Diff from the fork point so that only the changes introduced on the current branch are reported
+	if c.IsMergeBase() && result.Base.Source != NoBaseSource && baseSha != EmptyTreeSHA {
+		baseSha, err = ResolveMergeBase(client, &c, repoPath, baseSha)
+		if err != nil {
+			log.Panicf("Error getting merge base between commits: %v", err)
+		}
+		result.MergeBase = baseSha
+	}
+
+	if c.IsOnline() {
+		diffs, err = CompareGithubSHAs(client, &c, baseSha)
 		if err != nil {
 			log.Panicf("Error getting diff between commits: %v", err)
 		}
 	} else {
-		diffs, err = CompareGitFolderSHAs(repoPath, baseSha, cfg.Sha)
+		deepenGitFolderHistory(&c, repoPath, baseSha)
+		diffs, err = CompareGitFolderSHAs(repoPath, baseSha, c.Sha, c.CompareOptions())
 		if err != nil {
 			log.Panicf("Error getting diff between commits: %v", err)
 		}
 	}
 
-	deltas := FilterStrings(diffs, cfg.IncludesPatterns, cfg.ExcludesPatterns)
+	result.Changes = FilterFileChanges(diffs, c.IncludesPatterns, c.ExcludesPatterns)
+	result.Changes = FilterIgnoredChanges(result.Changes, c.IgnoreOptions())
+	result.Changes = FilterContentChanges(result.Changes, c.ContentIncludePatterns, c.ContentExcludePatterns)
+	minSize, maxSize := c.SizeLimits()
+	result.Changes = FilterFileSizes(result.Changes, minSize, maxSize)
+	result.Files = FileChangePaths(result.Changes)
+	return result
+}
 
-	if len(deltas) > 0 {
-		SetGitHubOutput("is_detected", "true")
-		jsonData, err := json.Marshal(deltas)
+// resolveNoBase applies the no_base policy when no base could be resolved. Returns EmptyTreeSHA to report every
+// file as changed with the all policy, the default, an empty string to report no changes with the none policy, or
+// the parent of the current SHA with the parent policy. The fail policy panics.
+func resolveNoBase(client *github.Client, cfg *InputConfig, repoPath string) string {
+	switch cfg.NoBase {
+	case "", "all":
+		log.Println("No base resolved, reporting every file as changed")
+		return EmptyTreeSHA
+	case "none":
+		log.Println("No base resolved, reporting no changes")
+		return ""
+	case "parent":
+		var parentSha string
+		var err error
+		if cfg.IsOnline() {
+			parentSha, err = GetGitHubParentSHA(client, cfg)
+		} else {
+			parentSha, err = GetGitFolderParentSHA(repoPath, cfg.Sha)
+		}
 		if err != nil {
-			log.Printf("Error marshalling to JSON: %v", err)
+			log.Panicf("Error getting parent of %s: %v", cfg.Sha, err)
 		}
-		SetGitHubOutput("delta_files", string(jsonData))
+		// A root commit introduces every file
+		if parentSha == "" {
+			log.Printf("No base resolved and %s has no parent, reporting every file as changed", cfg.Sha)
+			return EmptyTreeSHA
+		}
+		log.Printf("No base resolved, falling back to the parent %s", parentSha)
+		return parentSha
+	}
+	log.Panicf("No base could be resolved to compare %s against. Check the base chain resolves a base, or set no_base to all, none or parent", cfg.Sha)
+	return ""
+}
+
+// setDeltaOutputs sets the GitHub Actions output variables for the result, with the suffix appended to each name.
+func setDeltaOutputs(result DeltaResult, suffix string) {
+	SetGitHubOutput("base_sha"+suffix, result.Base.SHA)
+	SetGitHubOutput("base_source"+suffix, result.Base.Source)
+	SetGitHubOutput("head_sha"+suffix, result.Head)
+	if result.MergeBase != "" {
+		SetGitHubOutput("merge_base_sha"+suffix, result.MergeBase)
+	}
+	SetGitHubOutput("is_diverged"+suffix, strconv.FormatBool(result.Diverged))
+	if result.Diverged {
+		SetGitHubOutput("diverged_base_sha"+suffix, result.DivergedBase)
+	}
+
+	if len(result.Files) > 0 {
+		SetGitHubOutput("is_detected"+suffix, "true")
+		SetGitHubOutput("delta_files"+suffix, marshalOutput(result.Files))
+		SetGitHubOutput("delta_changes"+suffix, marshalOutput(result.Changes))
 	} else {
-		SetGitHubOutput("is_detected", "false")
+		SetGitHubOutput("is_detected"+suffix, "false")
+	}
+	additions, deletions, changes := SumLineStats(result.Changes)
+	SetGitHubOutput("additions"+suffix, strconv.Itoa(additions))
+	SetGitHubOutput("deletions"+suffix, strconv.Itoa(deletions))
+	SetGitHubOutput("changes"+suffix, strconv.Itoa(changes))
+	SetGitHubOutput("added_files"+suffix, marshalOutput(FileChangePathsByStatus(result.Changes, FileAdded)))
+	SetGitHubOutput("modified_files"+suffix, marshalOutput(FileChangePathsByStatus(result.Changes, FileModified)))
+	SetGitHubOutput("deleted_files"+suffix, marshalOutput(FileChangePathsByStatus(result.Changes, FileDeleted)))
+	SetGitHubOutput("renamed_files"+suffix, marshalOutput(FileChangePathsByStatus(result.Changes, FileRenamed)))
+	SetGitHubOutput("type_changed_files"+suffix, marshalOutput(FileChangePathsByStatus(result.Changes, FileTypeChanged)))
+	SetGitHubOutput("mode_changed_files"+suffix, marshalOutput(ModeChangedPaths(result.Changes)))
+	SetGitHubOutput("submodule_bumps"+suffix, marshalOutput(SubmoduleBumps(result.Changes)))
+	SetGitHubOutput("lfs_files"+suffix, marshalOutput(LFSChangedPaths(result.Changes)))
+}
+
+// marshalOutput encodes the value of an output as JSON.
+func marshalOutput(v any) string {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshalling to JSON: %v", err)
+	}
+	return string(jsonData)
+}
+
+// writeDeltaPatch writes the unified diff of the delta files to the patch_file of the environment, and sets the
+// "patch_file" and "patch_size" output variables with the suffix appended to each name. Does nothing without
+// patch_file.
+func writeDeltaPatch(cfg *InputConfig, repoPath, environment string, result DeltaResult, suffix string) {
+	if cfg.PatchFile == "" {
+		return
+	}
+	path := BaseFilePath(repoPath, cfg.PatchFile, environment)
+	size, err := WritePatchFile(path, result.Changes)
+	if err != nil {
+		log.Panicf("Error writing patch file: %v", err)
+	}
+	SetGitHubOutput("patch_file"+suffix, path)
+	SetGitHubOutput("patch_size"+suffix, strconv.Itoa(size))
+}
+
+// outputSuffix returns the suffix of the per environment outputs, e.g. "_staging" for the staging environment.
+// Characters not allowed in output names are replaced with underscores.
+func outputSuffix(environment string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, environment)
+}
+
+// Delta calculates the difference between the base SHA and the current SHA, and sets GitHub Actions
+// output variables with the results. The base SHA is resolved by the base chain, which by default uses
+// the commit input when given, otherwise the latest successful deployment of the environment, otherwise
+// the latest commit of the branch. When merge_base is set, the delta is calculated from the merge base of the
+// resolved base and the current SHA instead, reported in the "merge_base_sha" output. The "base_sha",
+// "base_source" and "head_sha" outputs report the resolved base, the resolver it came from and the head.
+// If there are any changes detected, the "is_detected" output is set to "true" and the "delta_files"
+// output is set to a JSON-encoded list of the changed files, with the "delta_changes" output holding their
+// status, hashes and modes. If there are no changes, the "is_detected" output is set to "false". The
+// "added_files", "modified_files", "deleted_files", "renamed_files" and "type_changed_files" outputs list the
+// changed files by status, the "mode_changed_files" output the modified files whose mode changed, and the
+// "submodule_bumps" output the old and new commits of the changed submodules. The "additions", "deletions" and
+// "changes" outputs count their changed lines.
+//
+// When patch_file is set, the unified diff of the changed files is written to it, reported in the "patch_file"
+// and "patch_size" outputs.
+//
+// When several environments are given, a delta is calculated for each of them with the outputs suffixed by
+// the environment name, e.g. "is_detected_staging". The "deltas" output holds a JSON-encoded map of the
+// changed files by environment, and the unsuffixed outputs cover the changed files of all environments.
+//
+// When the mode is record, no delta is calculated and the current SHA is recorded as the latest deployment
+// of the environments instead.
+func Delta(repoPath string) {
+	cfg := GetInputConfig()
+	cfg.Validate()
+	client := GetClient(&cfg)
+	cfg.Sha = ResolveHeadSHA(client, &cfg, repoPath)
+
+	if cfg.IsRecord() {
+		RecordDeployment(&cfg, repoPath)
+		return
+	}
+
+	if len(cfg.EnvironmentList) <= 1 {
+		result := CalculateDelta(client, &cfg, repoPath, NewResolverChain(client, &cfg, repoPath))
+		setDeltaOutputs(result, "")
+		writeDeltaPatch(&cfg, repoPath, cfg.Environment, result, "")
+		return
 	}
+
+	// Look up the deployments of all environments at once when the chain resolves environments. On error, each
+	// environment resolver looks up its deployments again and reports the error to the base chain.
+	var deploymentShas map[string]string
+	if !cfg.IsEnvironmentFromRef() && NewResolverChain(client, &cfg, repoPath).HasResolver("environment") {
+		var err error
+		if deploymentShas, err = GetLatestSuccessfulDeploymentShas(client, &cfg, cfg.EnvironmentList); err != nil {
+			log.Printf("Error looking up the deployments of all environments: %v", err)
+		}
+	}
+
+	deltas := make(map[string][]string)
+	var changes []FileChange
+	var diverged bool
+	for _, environment := range cfg.EnvironmentList {
+		envCfg := cfg
+		envCfg.Environment = environment
+		chain := NewResolverChain(client, &envCfg, repoPath).WithDeploymentShas(deploymentShas)
+
+		result := CalculateDelta(client, &envCfg, repoPath, chain)
+		setDeltaOutputs(result, outputSuffix(environment))
+		writeDeltaPatch(&cfg, repoPath, environment, result, outputSuffix(environment))
+
+		diverged = diverged || result.Diverged
+		deltas[environment] = append([]string{}, result.Files...)
+		changes = UnionFileChanges(changes, result.Changes)
+	}
+
+	SetGitHubOutput("deltas", marshalOutput(deltas))
+	setDeltaOutputs(DeltaResult{Head: cfg.Sha, Files: FileChangePaths(changes), Changes: changes, Diverged: diverged}, "")
 }
@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -130,3 +133,364 @@ func TestFilterStringsEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateDeltaOffline(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"live/dev/main.tf": "dev", "live/prod/main.tf": "prod", "README.md": "readme"},
+		map[string]string{"live/dev/main.tf": "dev v2", "README.md": "readme v2"},
+		map[string]string{"live/prod/main.tf": "prod v2"},
+	)
+
+	cfg := &InputConfig{
+		Sha:              shas[2],
+		EnvironmentList:  []string{"dev", "prod"},
+		IncludesPatterns: []string{"live/**/*"},
+	}
+	deploymentShas := map[string]string{"dev": shas[1]}
+
+	testCases := []struct {
+		name        string
+		environment string
+		chain       []string
+		expected    DeltaResult
+	}{
+		{
+			name:        "Deployed environment",
+			environment: "dev",
+			chain:       []string{"environment"},
+			expected: DeltaResult{
+				Base:  Base{SHA: shas[1], Source: "environment"},
+				Head:  shas[2],
+				Files: []string{"live/prod/main.tf"},
+			},
+		},
+		{
+			name:        "Never deployed environment falls back to the next resolver",
+			environment: "prod",
+			chain:       []string{"environment", "commit:" + shas[0]},
+			expected: DeltaResult{
+				Base:  Base{SHA: shas[0], Source: "commit"},
+				Head:  shas[2],
+				Files: []string{"live/dev/main.tf", "live/prod/main.tf"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			envCfg := *cfg
+			envCfg.Environment = tc.environment
+			envCfg.BaseChainEntries = tc.chain
+			chain := NewResolverChain(nil, &envCfg, repoPath).WithDeploymentShas(deploymentShas)
+
+			assertDeltaResult(t, tc.expected, CalculateDelta(nil, &envCfg, repoPath, chain))
+		})
+	}
+}
+
+func TestCalculateDeltaNoBase(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a", "b.txt": "b"},
+		map[string]string{"b.txt": "b v2"},
+	)
+
+	testCases := []struct {
+		name     string
+		sha      string
+		noBase   string
+		expected DeltaResult
+	}{
+		{
+			name:     "All files by default",
+			sha:      shas[1],
+			noBase:   "",
+			expected: DeltaResult{Base: Base{SHA: EmptyTreeSHA, Source: NoBaseSource}, Head: shas[1], Files: []string{"a.txt", "b.txt"}},
+		},
+		{
+			name:     "No files",
+			sha:      shas[1],
+			noBase:   "none",
+			expected: DeltaResult{Base: Base{Source: NoBaseSource}, Head: shas[1]},
+		},
+		{
+			name:     "Parent commit",
+			sha:      shas[1],
+			noBase:   "parent",
+			expected: DeltaResult{Base: Base{SHA: shas[0], Source: NoBaseSource}, Head: shas[1], Files: []string{"b.txt"}},
+		},
+		{
+			name:     "Root commit falls back to all files",
+			sha:      shas[0],
+			noBase:   "parent",
+			expected: DeltaResult{Base: Base{SHA: EmptyTreeSHA, Source: NoBaseSource}, Head: shas[0], Files: []string{"a.txt", "b.txt"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &InputConfig{Sha: tc.sha, NoBase: tc.noBase, BaseChainEntries: []string{"file:missing.sha"}}
+			chain := NewResolverChain(nil, cfg, repoPath)
+
+			assertDeltaResult(t, tc.expected, CalculateDelta(nil, cfg, repoPath, chain))
+		})
+	}
+
+	// The fail policy panics
+	cfg := &InputConfig{Sha: shas[1], NoBase: "fail", BaseChainEntries: []string{"file:missing.sha"}}
+	assert.Panics(t, func() { CalculateDelta(nil, cfg, repoPath, NewResolverChain(nil, cfg, repoPath)) })
+
+	// A failed lookup is not taken for a missing base
+	cfg = &InputConfig{Sha: shas[1]}
+	chain := ResolverChain{&stubResolver{name: "environment", err: errors.New("401 Bad credentials")}, &stubResolver{name: "branch"}}
+	assert.Panics(t, func() { CalculateDelta(nil, cfg, repoPath, chain) })
+}
+
+func TestCalculateDeltaEventHead(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+		map[string]string{"c.txt": "c"},
+	)
+	eventPath := filepath.Join(t.TempDir(), "push.json")
+	if err := os.WriteFile(eventPath, []byte(`{"before": "`+shas[0]+`", "after": "`+shas[1]+`"}`), 0600); err != nil {
+		t.Fatalf("Error writing event payload: %v", err)
+	}
+
+	// The head of the event replaces the current SHA
+	cfg := &InputConfig{Sha: shas[2], EventName: "push", EventPath: eventPath, BaseChainEntries: []string{"event"}}
+	expected := DeltaResult{Base: Base{SHA: shas[0], Source: "event", Head: shas[1]}, Head: shas[1], Files: []string{"b.txt"}}
+	assertDeltaResult(t, expected, CalculateDelta(nil, cfg, repoPath, NewResolverChain(nil, cfg, repoPath)))
+
+	// The head input takes priority over the head of the event
+	cfg.Head = shas[2]
+	expected = DeltaResult{Base: Base{SHA: shas[0], Source: "event", Head: shas[1]}, Head: shas[2], Files: []string{"b.txt", "c.txt"}}
+	assertDeltaResult(t, expected, CalculateDelta(nil, cfg, repoPath, NewResolverChain(nil, cfg, repoPath)))
+}
+
+func TestCalculateDeltaDivergedBase(t *testing.T) {
+	t.Parallel()
+	// main: c0 -> c1 -> c2, rewritten: c0 -> c1 -> r
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a", "b.txt": "b"},
+		map[string]string{"a.txt": "a v2"},
+		map[string]string{"b.txt": "b v2"},
+	)
+	checkoutTestCommit(t, repoPath, shas[1])
+	rewritten := addTestCommit(t, repoPath, map[string]string{"c.txt": "c"})
+
+	testCases := []struct {
+		name     string
+		source   string
+		base     string
+		strategy string
+		expected DeltaResult
+	}{
+		{
+			name:   "Base in the history",
+			source: "file",
+			base:   shas[1],
+			expected: DeltaResult{
+				Base:  Base{SHA: shas[1], Source: "file"},
+				Head:  shas[2],
+				Files: []string{"b.txt"},
+			},
+		},
+		{
+			name:   "Merge base by default",
+			source: "file",
+			base:   rewritten,
+			expected: DeltaResult{
+				Base:         Base{SHA: rewritten, Source: "file"},
+				Diverged:     true,
+				DivergedBase: shas[1],
+				Head:         shas[2],
+				Files:        []string{"b.txt"},
+			},
+		},
+		{
+			name:     "All files",
+			source:   "file",
+			base:     rewritten,
+			strategy: DivergedAll,
+			expected: DeltaResult{
+				Base:         Base{SHA: rewritten, Source: "file"},
+				Diverged:     true,
+				DivergedBase: EmptyTreeSHA,
+				Head:         shas[2],
+				Files:        []string{"a.txt", "b.txt"},
+			},
+		},
+		{
+			name:     "Previous deployment falls back to the merge base without deployments",
+			source:   "file",
+			base:     rewritten,
+			strategy: DivergedPreviousDeployment,
+			expected: DeltaResult{
+				Base:         Base{SHA: rewritten, Source: "file"},
+				Diverged:     true,
+				DivergedBase: shas[1],
+				Head:         shas[2],
+				Files:        []string{"b.txt"},
+			},
+		},
+		{
+			name:   "Commit base is compared as it is",
+			source: "commit",
+			base:   rewritten,
+			expected: DeltaResult{
+				Base:  Base{SHA: rewritten, Source: "commit"},
+				Head:  shas[2],
+				Files: []string{"b.txt", "c.txt"},
+			},
+		},
+		{
+			name:   "Before SHA of a force push",
+			source: "push",
+			base:   rewritten,
+			expected: DeltaResult{
+				Base:         Base{SHA: rewritten, Source: "event", Head: shas[2]},
+				Diverged:     true,
+				DivergedBase: shas[1],
+				Head:         shas[2],
+				Files:        []string{"b.txt"},
+			},
+		},
+		{
+			name:   "Pull request base is compared as it is",
+			source: "pull_request",
+			base:   rewritten,
+			expected: DeltaResult{
+				Base:  Base{SHA: rewritten, Source: "event", Head: shas[2]},
+				Head:  shas[2],
+				Files: []string{"b.txt", "c.txt"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &InputConfig{Sha: shas[2], DivergedBase: tc.strategy, BaseChainEntries: []string{"commit:" + tc.base}}
+			switch tc.source {
+			case "file":
+				baseFile := filepath.Join(t.TempDir(), "base")
+				if err := os.WriteFile(baseFile, []byte(tc.base), 0o644); err != nil {
+					t.Fatalf("Error writing base file: %v", err)
+				}
+				cfg.BaseChainEntries = []string{"file:" + baseFile}
+			case "push", "pull_request":
+				payload := `{"before": "` + tc.base + `", "after": "` + shas[2] + `"}`
+				if tc.source == "pull_request" {
+					payload = `{"pull_request": {"base": {"sha": "` + tc.base + `"}, "head": {"sha": "` + shas[2] + `"}}}`
+				}
+				cfg.EventName, cfg.EventPath = tc.source, filepath.Join(t.TempDir(), "event.json")
+				if err := os.WriteFile(cfg.EventPath, []byte(payload), 0o600); err != nil {
+					t.Fatalf("Error writing event payload: %v", err)
+				}
+				cfg.BaseChainEntries = []string{"event"}
+			}
+			chain := NewResolverChain(nil, cfg, repoPath)
+
+			assertDeltaResult(t, tc.expected, CalculateDelta(nil, cfg, repoPath, chain))
+		})
+	}
+}
+
+// assertDeltaResult asserts the result equals the expected one, with the changes matching the files.
+func assertDeltaResult(t *testing.T, expected, result DeltaResult) {
+	t.Helper()
+	assert.Equal(t, result.Files, FileChangePaths(result.Changes))
+	result.Changes = nil
+	assert.Equal(t, expected, result)
+}
+
+func TestSetDeltaOutputs(t *testing.T) {
+	outputFile := filepath.Join(t.TempDir(), "output")
+	if err := os.WriteFile(outputFile, nil, 0600); err != nil {
+		t.Fatalf("Error creating output file: %v", err)
+	}
+	t.Setenv("GITHUB_OUTPUT", outputFile)
+
+	setDeltaOutputs(DeltaResult{
+		Base:  Base{SHA: "base", Source: "environment"},
+		Head:  "head",
+		Files: []string{"a.txt", "b.txt"},
+		Changes: []FileChange{
+			{Path: "a.txt", Status: FileAdded, NewHash: "1", NewMode: "100644", Additions: 2, Changes: 2},
+			{Path: "b.txt", Status: FileDeleted, Deletions: 1, Changes: 1},
+		},
+	}, outputSuffix("staging"))
+	setDeltaOutputs(DeltaResult{Head: "head", Diverged: true, DivergedBase: "fork"}, outputSuffix("prod eu"))
+
+	output, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, `base_sha_staging=base
+base_source_staging=environment
+head_sha_staging=head
+is_diverged_staging=false
+is_detected_staging=true
+delta_files_staging=["a.txt","b.txt"]
+delta_changes_staging=[{"path":"a.txt","status":"added","new_hash":"1","new_mode":"100644","additions":2,"deletions":0,"changes":2},{"path":"b.txt","status":"deleted","additions":0,"deletions":1,"changes":1}]
+additions_staging=2
+deletions_staging=1
+changes_staging=3
+added_files_staging=["a.txt"]
+modified_files_staging=[]
+deleted_files_staging=["b.txt"]
+renamed_files_staging=[]
+type_changed_files_staging=[]
+mode_changed_files_staging=[]
+submodule_bumps_staging=[]
+lfs_files_staging=[]
+base_sha_prod_eu=
+base_source_prod_eu=
+head_sha_prod_eu=head
+is_diverged_prod_eu=true
+diverged_base_sha_prod_eu=fork
+is_detected_prod_eu=false
+additions_prod_eu=0
+deletions_prod_eu=0
+changes_prod_eu=0
+added_files_prod_eu=[]
+modified_files_prod_eu=[]
+deleted_files_prod_eu=[]
+renamed_files_prod_eu=[]
+type_changed_files_prod_eu=[]
+mode_changed_files_prod_eu=[]
+submodule_bumps_prod_eu=[]
+lfs_files_prod_eu=[]
+`, string(output))
+}
+
+func TestWriteDeltaPatch(t *testing.T) {
+	repoPath := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output")
+	if err := os.WriteFile(outputFile, nil, 0600); err != nil {
+		t.Fatalf("Error creating output file: %v", err)
+	}
+	t.Setenv("GITHUB_OUTPUT", outputFile)
+
+	cfg := &InputConfig{Sha: "head", PatchFile: "patches/{environment}.patch"}
+	result := DeltaResult{Changes: []FileChange{
+		{Path: "a.txt", Status: FileModified, Patch: "diff --git a/a.txt b/a.txt\n"},
+		{Path: "b.txt", Status: FileModified, Patch: "diff --git a/b.txt b/b.txt\n"},
+	}}
+	writeDeltaPatch(cfg, repoPath, "staging", result, outputSuffix("staging"))
+
+	patchPath := filepath.Join(repoPath, "patches", "staging.patch")
+	patch, err := os.ReadFile(patchPath)
+	if err != nil {
+		t.Fatalf("Error reading patch file: %v", err)
+	}
+	assert.Equal(t, "diff --git a/a.txt b/a.txt\ndiff --git a/b.txt b/b.txt\n", string(patch))
+
+	output, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, "patch_file_staging="+patchPath+"\npatch_size_staging=54\n", string(output))
+}
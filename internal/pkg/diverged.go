@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/google/go-github/v66/github"
+)
+
+const (
+	// DivergedMergeBase compares a diverged base from its merge base with the current SHA
+	DivergedMergeBase = "merge-base"
+	// DivergedPreviousDeployment compares a diverged base from the latest earlier deployment of the environment
+	// that is still in the history of the current SHA
+	DivergedPreviousDeployment = "previous-deployment"
+	// DivergedAll reports every file as changed for a diverged base
+	DivergedAll = "all"
+)
+
+// divergedStrategies lists the accepted diverged_base strategies
+var divergedStrategies = []string{DivergedMergeBase, DivergedPreviousDeployment, DivergedAll}
+
+// divergedSources lists the base sources recording a past deployment or run, whose base may have been rewritten
+// since. Bases chosen for the comparison, e.g. a branch, a merge base or a commit, are compared as they are.
+var divergedSources = []string{"environment", "file", "workflow-run", "event"}
+
+// IsDivergedSource reports whether a base from the source is checked for divergence from the current SHA. Only the
+// before SHA of a push event is, as the base of a pull request or merge group is the tip of the base branch, which
+// is not an ancestor of the head once the base branch moved ahead.
+func IsDivergedSource(cfg *InputConfig, source string) bool {
+	if source == "event" {
+		return cfg.EventName == "push"
+	}
+	return slices.Contains(divergedSources, source)
+}
+
+// IsDivergedBase reports whether the base is no longer in the history of the current SHA, either because it is
+// not an ancestor of the current SHA, e.g. after a force push or a deployment from another branch, or because
+// it does not exist anymore, e.g. after the rewritten commits were garbage collected. Offline, a missing base is
+// only diverged when the repository holds the entire history, and an error otherwise.
+func IsDivergedBase(client *github.Client, cfg *InputConfig, repoPath, baseSha string) (bool, error) {
+	if cfg.IsOnline() {
+		isAncestor, err := IsGitHubAncestor(client, cfg, baseSha)
+		// The compare API does not find a missing commit
+		if isGitHubNotFound(err) {
+			return true, nil
+		}
+		return !isAncestor, err
+	}
+
+	deepenGitFolderHistory(cfg, repoPath, baseSha)
+	if _, err := GetGitFolderCommitSHA(repoPath, baseSha); err != nil {
+		// A shallow clone may just not hold the base, so it is only missing with the entire history
+		shallow, shallowErr := IsGitFolderShallow(repoPath)
+		if shallowErr != nil {
+			return false, shallowErr
+		}
+		if shallow {
+			return false, fmt.Errorf("base %s is not in the shallow clone, fetch the entire history with fetch-depth: 0: %v", baseSha, err)
+		}
+		return true, nil
+	}
+	isAncestor, err := IsGitFolderAncestor(repoPath, baseSha, cfg.Sha)
+	return !isAncestor, err
+}
+
+// ResolveDivergedBase returns the SHA to compare against instead of a diverged base, following the diverged_base
+// strategy. The previous deployment strategy falls back to the merge base when no earlier deployment is in the
+// history, and the merge base strategy to every file when the base has no merge base with the current SHA.
+func ResolveDivergedBase(client *github.Client, cfg *InputConfig, repoPath string, base Base) string {
+	switch cfg.DivergedBase {
+	case DivergedPreviousDeployment:
+		if base.Source == "environment" && !cfg.IsEnvironmentFromRef() {
+			sha, err := GetPreviousSuccessfulDeploymentSha(client, cfg, reachableFrom(client, cfg, repoPath, base.SHA))
+			switch {
+			case err != nil:
+				log.Printf("Could not get the previous deployment of %s: %v", cfg.Environment, err)
+			case sha != "":
+				log.Printf("Base %s diverged, using the previous deployment %s", base.SHA, sha)
+				return sha
+			default:
+				log.Printf("No earlier deployment of %s is in the history of %s", cfg.Environment, cfg.Sha)
+			}
+		} else {
+			log.Printf("Base from %s has no previous deployments", base.Source)
+		}
+		fallthrough
+	case "", DivergedMergeBase:
+		mergeBase, err := ResolveMergeBase(client, cfg, repoPath, base.SHA)
+		if err == nil && mergeBase != "" {
+			log.Printf("Base %s diverged, using the merge base %s", base.SHA, mergeBase)
+			return mergeBase
+		}
+		log.Printf("Could not get merge base of %s and %s: %v", base.SHA, cfg.Sha, err)
+		fallthrough
+	default:
+		log.Printf("Base %s diverged, reporting every file as changed", base.SHA)
+		return EmptyTreeSHA
+	}
+}
+
+// reachableFrom returns whether a deployed SHA other than the diverged base is in the history of the current SHA,
+// checking each SHA only once.
+func reachableFrom(client *github.Client, cfg *InputConfig, repoPath, divergedSha string) func(sha string) bool {
+	checked := map[string]bool{divergedSha: false}
+	return func(sha string) bool {
+		if reachable, found := checked[sha]; found {
+			return reachable
+		}
+		diverged, err := IsDivergedBase(client, cfg, repoPath, sha)
+		if err != nil {
+			log.Printf("Error checking deployment SHA %s is an ancestor of %s: %v", sha, cfg.Sha, err)
+		}
+		checked[sha] = err == nil && !diverged
+		return checked[sha]
+	}
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDivergedBaseOnline(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Deployments newest first: a force pushed commit, a garbage collected commit and a commit in the history
+	mux.HandleFunc("/repos/owner/repo/deployments", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 1, "sha": "rewritten1"}, {"id": 2, "sha": "gone2"}, {"id": 3, "sha": "old3"}]`)
+	})
+	for id := 1; id <= 3; id++ {
+		mux.HandleFunc(fmt.Sprintf("/repos/owner/repo/deployments/%d/statuses", id), func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"state": "success"}]`)
+		})
+	}
+
+	// Mock the CompareCommits endpoint
+	mux.HandleFunc("/repos/owner/repo/compare/rewritten1...head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "diverged", "merge_base_commit": {"sha": "fork789"}}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/compare/gone2...head456", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	})
+	mux.HandleFunc("/repos/owner/repo/compare/old3...head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "ahead", "merge_base_commit": {"sha": "old3"}}`)
+	})
+
+	cfg := &InputConfig{Repo: "owner/repo", Sha: "head456", Environment: "production", Online: "true"}
+
+	for sha, expected := range map[string]bool{"rewritten1": true, "gone2": true, "old3": false} {
+		diverged, err := IsDivergedBase(client, cfg, ".", sha)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assert.Equal(t, expected, diverged, "IsDivergedBase(%s)", sha)
+	}
+
+	testCases := []struct {
+		strategy string
+		base     Base
+		expected string
+	}{
+		{strategy: "", base: Base{SHA: "rewritten1", Source: "environment"}, expected: "fork789"},
+		{strategy: DivergedPreviousDeployment, base: Base{SHA: "rewritten1", Source: "environment"}, expected: "old3"},
+		{strategy: DivergedPreviousDeployment, base: Base{SHA: "rewritten1", Source: "commit"}, expected: "fork789"},
+		{strategy: DivergedMergeBase, base: Base{SHA: "gone2", Source: "environment"}, expected: EmptyTreeSHA},
+		{strategy: DivergedAll, base: Base{SHA: "rewritten1", Source: "environment"}, expected: EmptyTreeSHA},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.strategy+" "+tc.base.SHA+" "+tc.base.Source, func(t *testing.T) {
+			strategyCfg := *cfg
+			strategyCfg.DivergedBase = tc.strategy
+			assert.Equal(t, tc.expected, ResolveDivergedBase(client, &strategyCfg, ".", tc.base))
+		})
+	}
+}
+
+func TestDivergedBaseOffline(t *testing.T) {
+	t.Parallel()
+	// main: c0 -> c1 -> c2, rewritten: c0 -> r
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"a.txt": "a v2"},
+		map[string]string{"a.txt": "a v3"},
+	)
+	checkoutTestCommit(t, repoPath, shas[0])
+	rewritten := addTestCommit(t, repoPath, map[string]string{"b.txt": "b"})
+	checkoutTestCommit(t, repoPath, shas[2])
+	missing := "0123456789abcdef0123456789abcdef01234567"
+
+	cfg := &InputConfig{Sha: shas[2]}
+	for sha, expected := range map[string]bool{shas[1]: false, rewritten: true, missing: true} {
+		diverged, err := IsDivergedBase(nil, cfg, repoPath, sha)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assert.Equal(t, expected, diverged, "IsDivergedBase(%s)", sha)
+	}
+
+	// A shallow clone may just not hold the base
+	clonePath := newTestShallowClone(t, repoPath, false)
+	diverged, err := IsDivergedBase(nil, cfg, clonePath, missing)
+	assert.Error(t, err)
+	assert.False(t, diverged)
+}
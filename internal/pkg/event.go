@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v66/github"
+)
+
+// EventRange holds the base and head SHAs derived from the event that triggered the workflow
+type EventRange struct {
+	Base string
+	Head string
+}
+
+// GetEventRange reads the event payload at eventPath and derives the commit range from the event type:
+// the base and head of the pull request for pull_request events, the before and after SHAs for push
+// events and the base and head of the merge group for merge_group events.
+// The base is empty when the event has no previous commit, e.g. a push creating a new branch.
+func GetEventRange(eventPath, eventName string) (EventRange, error) {
+	if eventPath == "" {
+		return EventRange{}, fmt.Errorf("event payload path is not set")
+	}
+
+	payload, err := os.ReadFile(eventPath)
+	if err != nil {
+		return EventRange{}, fmt.Errorf("could not read event payload: %v", err)
+	}
+
+	event, err := github.ParseWebHook(eventName, payload)
+	if err != nil {
+		return EventRange{}, fmt.Errorf("could not parse %s event payload: %v", eventName, err)
+	}
+
+	var r EventRange
+	switch e := event.(type) {
+	case *github.PullRequestEvent:
+		r.Base = e.GetPullRequest().GetBase().GetSHA()
+		r.Head = e.GetPullRequest().GetHead().GetSHA()
+	case *github.PullRequestTargetEvent:
+		r.Base = e.GetPullRequest().GetBase().GetSHA()
+		r.Head = e.GetPullRequest().GetHead().GetSHA()
+	case *github.PushEvent:
+		r.Base = e.GetBefore()
+		r.Head = e.GetAfter()
+	case *github.MergeGroupEvent:
+		r.Base = e.GetMergeGroup().GetBaseSHA()
+		r.Head = e.GetMergeGroup().GetHeadSHA()
+	default:
+		return EventRange{}, fmt.Errorf("unsupported event %s for deriving the commit range", eventName)
+	}
+
+	// A zero SHA means there is no previous commit, e.g. the push created the branch
+	if isZeroSHA(r.Base) {
+		r.Base = ""
+	}
+	if isZeroSHA(r.Head) {
+		r.Head = ""
+	}
+	return r, nil
+}
+
+// isZeroSHA reports whether the SHA consists of zeros only, which GitHub uses for a missing commit.
+func isZeroSHA(sha string) bool {
+	return sha != "" && strings.Trim(sha, "0") == ""
+}
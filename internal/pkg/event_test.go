@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEventRange(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		eventName     string
+		payload       string
+		expected      EventRange
+		expectedError bool
+	}{
+		{
+			name:      "Pull request event",
+			eventName: "pull_request",
+			payload:   `{"pull_request": {"base": {"sha": "base123"}, "head": {"sha": "head456"}}}`,
+			expected:  EventRange{Base: "base123", Head: "head456"},
+		},
+		{
+			name:      "Pull request target event",
+			eventName: "pull_request_target",
+			payload:   `{"pull_request": {"base": {"sha": "base123"}, "head": {"sha": "head456"}}}`,
+			expected:  EventRange{Base: "base123", Head: "head456"},
+		},
+		{
+			name:      "Push event",
+			eventName: "push",
+			payload:   `{"before": "before123", "after": "after456"}`,
+			expected:  EventRange{Base: "before123", Head: "after456"},
+		},
+		{
+			name:      "Push event creating a branch",
+			eventName: "push",
+			payload:   `{"before": "0000000000000000000000000000000000000000", "after": "after456", "created": true}`,
+			expected:  EventRange{Base: "", Head: "after456"},
+		},
+		{
+			name:      "Merge group event",
+			eventName: "merge_group",
+			payload:   `{"merge_group": {"base_sha": "base123", "head_sha": "head456"}}`,
+			expected:  EventRange{Base: "base123", Head: "head456"},
+		},
+		{
+			name:          "Unsupported event",
+			eventName:     "release",
+			payload:       `{}`,
+			expectedError: true,
+		},
+		{
+			name:          "Unknown event",
+			eventName:     "unknown",
+			payload:       `{}`,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid payload",
+			eventName:     "push",
+			payload:       `not json`,
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventPath := filepath.Join(t.TempDir(), "event.json")
+			if err := os.WriteFile(eventPath, []byte(tt.payload), 0600); err != nil {
+				t.Fatalf("Error writing event payload: %v", err)
+			}
+
+			eventRange, err := GetEventRange(eventPath, tt.eventName)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, eventRange)
+		})
+	}
+
+	_, err := GetEventRange("", "push")
+	assert.Error(t, err)
+	_, err = GetEventRange(filepath.Join(t.TempDir(), "missing.json"), "push")
+	assert.Error(t, err)
+}
+
+func TestIsZeroSHA(t *testing.T) {
+	t.Parallel()
+	assert.True(t, isZeroSHA("0000000000000000000000000000000000000000"))
+	assert.False(t, isZeroSHA(""))
+	assert.False(t, isZeroSHA("0000000000000000000000000000000000000001"))
+}
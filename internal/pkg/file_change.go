@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+// FileStatus is the kind of change of a file between the base and the head
+type FileStatus string
+
+const (
+	FileAdded       FileStatus = "added"
+	FileModified    FileStatus = "modified"
+	FileDeleted     FileStatus = "deleted"
+	FileRenamed     FileStatus = "renamed"
+	FileCopied      FileStatus = "copied"
+	FileTypeChanged FileStatus = "type-changed"
+)
+
+// FileChange describes the change of a file between the base and the head. The old hash and mode are empty for
+// an added file, the new ones for a deleted file. Modes are octal Git modes such as 100644.
+type FileChange struct {
+	// Path is the path of the file in the head, or in the base for a deleted file
+	Path string `json:"path"`
+	// PreviousPath is the path of the file in the base for a renamed or copied file
+	PreviousPath string     `json:"previous_path,omitempty"`
+	Status       FileStatus `json:"status"`
+	OldHash      string     `json:"old_hash,omitempty"`
+	NewHash      string     `json:"new_hash,omitempty"`
+	OldMode      string     `json:"old_mode,omitempty"`
+	NewMode      string     `json:"new_mode,omitempty"`
+	// Additions, Deletions and Changes are the numbers of lines added, deleted, and both, by the change
+	Additions int `json:"additions"`
+	Deletions int `json:"deletions"`
+	Changes   int `json:"changes"`
+	// OldSize and NewSize are the sizes in bytes of the blobs, or of the Git LFS objects for pointer files
+	OldSize int64 `json:"old_size,omitempty"`
+	NewSize int64 `json:"new_size,omitempty"`
+	// LFS is the change of the Git LFS objects, for a pointer file in the base or in the head
+	LFS *LFSChange `json:"lfs,omitempty"`
+	// Submodule is the path of the submodule holding the file, for the changes inside a bumped submodule
+	Submodule string `json:"submodule,omitempty"`
+	// Patch is the unified diff of the file, only set when requested in the CompareOptions
+	Patch string `json:"-"`
+}
+
+// CompareOptions holds the options of the comparison between the base and the head
+type CompareOptions struct {
+	// RenameThreshold is the similarity in percent from which a deleted and an added file are a rename, zero not
+	// detecting renames
+	RenameThreshold int
+	// DetectCopies reports the added files similar to a file of the base as copies
+	DetectCopies bool
+	// Patches sets the unified diff of each file
+	Patches bool
+	// RecurseSubmodules lists the changes of the files inside the bumped submodules
+	RecurseSubmodules bool
+	// IncludePatterns and ExcludePatterns skip the changes filtered out by FilterFileChanges before their lines
+	// are counted. Submodule bumps are kept, so that the files inside them can be listed.
+	IncludePatterns []string
+	ExcludePatterns []string
+}
+
+// formatFileMode formats a Git file mode as in a tree, e.g. 100644, or an empty string for a missing file.
+func formatFileMode(mode filemode.FileMode) string {
+	if mode == filemode.Empty {
+		return ""
+	}
+	return fmt.Sprintf("%06o", uint32(mode))
+}
+
+// FileChangePaths returns the paths of the changes, in order.
+func FileChangePaths(changes []FileChange) []string {
+	var paths []string
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+	return paths
+}
+
+// FilterFileChanges filters the changes on their path based on inclusion and exclusion patterns, like FilterStrings.
+// A renamed or copied file is kept when either its path or its previous path matches, so that a file moved out of
+// an included folder is still reported.
+func FilterFileChanges(changes []FileChange, includePatterns, excludePatterns []string) []FileChange {
+	var result []FileChange
+	for _, change := range changes {
+		paths := []string{change.Path}
+		if change.PreviousPath != "" {
+			paths = append(paths, change.PreviousPath)
+		}
+		if len(FilterStrings(paths, includePatterns, excludePatterns)) > 0 {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// filterComparedChanges keeps the changes kept by the filters of the comparison options, and the submodule bumps
+// so that the files inside them can be listed, before the blobs of the changes are read.
+func filterComparedChanges(changes []FileChange, opts CompareOptions) []FileChange {
+	var result []FileChange
+	for _, change := range changes {
+		if isSubmoduleBump(change) || len(FilterFileChanges([]FileChange{change}, opts.IncludePatterns, opts.ExcludePatterns)) > 0 {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// UnionFileChanges appends the changes of other whose path is not in changes yet, e.g. to combine the deltas of
+// several environments. The change of a path already in changes is kept, even if it differs.
+func UnionFileChanges(changes, other []FileChange) []FileChange {
+	paths := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		paths[change.Path] = true
+	}
+	for _, change := range other {
+		if !paths[change.Path] {
+			paths[change.Path] = true
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// SumLineStats returns the total numbers of lines added, deleted, and both, by the changes.
+func SumLineStats(changes []FileChange) (additions, deletions, total int) {
+	for _, change := range changes {
+		additions += change.Additions
+		deletions += change.Deletions
+		total += change.Changes
+	}
+	return additions, deletions, total
+}
+
+// FileChangePathsByStatus returns the paths of the changes with the given status, in order.
+func FileChangePathsByStatus(changes []FileChange, status FileStatus) []string {
+	paths := []string{}
+	for _, change := range changes {
+		if change.Status == status {
+			paths = append(paths, change.Path)
+		}
+	}
+	return paths
+}
+
+// ModeChangedPaths returns the paths of the modified files whose mode changed within their type, e.g. a file
+// becoming executable, in order.
+func ModeChangedPaths(changes []FileChange) []string {
+	paths := []string{}
+	for _, change := range changes {
+		if change.Status == FileModified && change.OldMode != "" && change.NewMode != "" && change.OldMode != change.NewMode {
+			paths = append(paths, change.Path)
+		}
+	}
+	return paths
+}
+
+// WritePatchFile writes the unified diffs of the changes to a file, creating its folder if needed.
+// Returns the size of the file in bytes.
+func WritePatchFile(path string, changes []FileChange) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("could not create folder for patch file: %v", err)
+	}
+
+	var content []byte
+	for _, change := range changes {
+		content = append(content, change.Patch...)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return 0, fmt.Errorf("could not write patch file: %v", err)
+	}
+	return len(content), nil
+}
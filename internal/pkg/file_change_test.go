@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterFileChanges(t *testing.T) {
+	t.Parallel()
+	changes := []FileChange{
+		{Path: "live/dev/main.tf", Status: FileModified},
+		{Path: "modules/prod/main.tf", PreviousPath: "live/prod/main.tf", Status: FileRenamed},
+		{Path: "live/prod/vars.tf", PreviousPath: "live/prod/main.tf", Status: FileCopied},
+		{Path: "live/prod/old.tf", Status: FileDeleted},
+	}
+
+	tests := []struct {
+		name            string
+		includePatterns []string
+		excludePatterns []string
+		expected        []string
+	}{
+		{
+			name:     "No patterns",
+			expected: []string{"live/dev/main.tf", "modules/prod/main.tf", "live/prod/vars.tf", "live/prod/old.tf"},
+		},
+		{
+			name:            "Previous path matches the includes",
+			includePatterns: []string{"live/prod/**"},
+			expected:        []string{"modules/prod/main.tf", "live/prod/vars.tf", "live/prod/old.tf"},
+		},
+		{
+			name:            "Previous path matches the excludes",
+			excludePatterns: []string{"live/prod/**"},
+			expected:        []string{"live/dev/main.tf", "modules/prod/main.tf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			result := FilterFileChanges(changes, tt.includePatterns, tt.excludePatterns)
+			assert.Equal(t, tt.expected, FileChangePaths(result))
+		})
+	}
+}
+
+func TestWritePatchFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "nested", "delta.patch")
+
+	size, err := WritePatchFile(path, []FileChange{
+		{Path: "a.txt", Patch: "diff --git a/a.txt b/a.txt\n"},
+		{Path: "b.txt"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading patch file: %v", err)
+	}
+	assert.Equal(t, "diff --git a/a.txt b/a.txt\n", string(content))
+	assert.Equal(t, len(content), size)
+}
+
+func TestModeChangedPaths(t *testing.T) {
+	t.Parallel()
+	changes := []FileChange{
+		{Path: "run.sh", Status: FileModified, OldMode: "100644", NewMode: "100755"},
+		{Path: "main.tf", Status: FileModified, OldMode: "100644", NewMode: "100644"},
+		{Path: "link", Status: FileTypeChanged, OldMode: "100644", NewMode: "120000"},
+		{Path: "online.txt", Status: FileModified},
+	}
+	assert.Equal(t, []string{"run.sh"}, ModeChangedPaths(changes))
+	assert.Equal(t, []string{}, ModeChangedPaths(nil))
+}
+
+func TestUnionFileChanges(t *testing.T) {
+	t.Parallel()
+	// The same file changed from different bases in two environments
+	staging := []FileChange{
+		{Path: "main.tf", Status: FileModified, OldHash: "a2", NewHash: "a3"},
+		{Path: "new.tf", Status: FileAdded, NewHash: "b1"},
+	}
+	prod := []FileChange{
+		{Path: "main.tf", Status: FileModified, OldHash: "a1", NewHash: "a3"},
+		{Path: "new.tf", Status: FileAdded, NewHash: "b1"},
+		{Path: "old.tf", Status: FileDeleted, OldHash: "c1"},
+	}
+
+	changes := UnionFileChanges(UnionFileChanges(nil, staging), prod)
+	assert.Equal(t, []FileChange{staging[0], staging[1], prod[2]}, changes)
+	assert.Equal(t, []string{"main.tf"}, FileChangePathsByStatus(changes, FileModified))
+}
@@ -1,64 +1,262 @@
 package internal
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
+const (
+	// EmptyTreeSHA is the SHA of the empty Git tree. Comparing against it reports every file of the other commit as added.
+	EmptyTreeSHA = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	// copyDetectionLimit is the number of files of the first tree above which only identical copies are detected
+	copyDetectionLimit = 1000
+)
+
 // CompareGitFolderSHAs retrieves the list of files that have changed between two commits identified by their SHAs.
-// It takes the repository path and the two commit SHAs as input parameters.
-// Returns a slice of strings containing the names of the changed files and an error if any occurs.
-func CompareGitFolderSHAs(repoPath, sha1, sha2 string) ([]string, error) {
+// It takes the repository path and the two commit SHAs, or any revision expressions, as input parameters. The first
+// SHA can be EmptyTreeSHA to list every file of the second commit. Renames and copies are detected, and the
+// submodules checked out in the worktree compared, as set in the options.
+// Returns the changes of the files and an error if any occurs.
+func CompareGitFolderSHAs(repoPath, sha1, sha2 string, opts CompareOptions) ([]FileChange, error) {
 	// Open the repository at the given path
 	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("could not open repository: %v", err)
 	}
 
-	// Get the commits corresponding to the given SHAs
-	commit1, err := repo.CommitObject(plumbing.NewHash(sha1))
+	// Get the tree objects for both commits
+	tree1, err := getGitFolderTree(repo, sha1)
 	if err != nil {
-		return nil, fmt.Errorf("could not find commit for SHA %s: %v", sha1, err)
+		return nil, withShallowHint(repo, err)
 	}
 
-	commit2, err := repo.CommitObject(plumbing.NewHash(sha2))
+	tree2, err := getGitFolderTree(repo, sha2)
 	if err != nil {
-		return nil, fmt.Errorf("could not find commit for SHA %s: %v", sha2, err)
+		return nil, withShallowHint(repo, err)
 	}
 
-	// Get the tree objects for both commits
-	tree1, err := commit1.Tree()
+	// Get the diff between the two trees, pairing deleted and added files into renames
+	changes, err := object.DiffTreeWithOptions(context.Background(), tree1, tree2, &object.DiffTreeOptions{
+		DetectRenames: opts.RenameThreshold > 0,
+		RenameScore:   uint(opts.RenameThreshold),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not get tree for commit %s: %v", sha1, err)
+		return nil, fmt.Errorf("could not get diff between trees: %v", err)
+	}
+
+	var copies map[string]*object.Change
+	if opts.DetectCopies {
+		copies, err = detectGitFolderCopies(tree1, changes, opts.RenameThreshold)
+		if err != nil {
+			return nil, fmt.Errorf("could not detect copies: %v", err)
+		}
 	}
 
-	tree2, err := commit2.Tree()
+	// Describe the change of each file
+	var diffFiles []FileChange
+	for _, change := range changes {
+		copied, isCopy := copies[change.To.Name]
+		if isCopy {
+			change = copied
+		}
+
+		fileChange := newGitFolderFileChange(change)
+		if isCopy {
+			fileChange.Status = FileCopied
+		}
+		// Only diff the blobs of the files kept by the filters
+		if len(filterComparedChanges([]FileChange{fileChange}, opts)) == 0 {
+			continue
+		}
+		if err := setGitFolderDiff(&fileChange, change, opts.Patches); err != nil {
+			return nil, fmt.Errorf("could not get diff of %s: %v", fileChange.Path, err)
+		}
+		if err := setGitFolderObjects(&fileChange, change); err != nil {
+			return nil, fmt.Errorf("could not read blobs of %s: %v", fileChange.Path, err)
+		}
+		diffFiles = append(diffFiles, fileChange)
+	}
+
+	if opts.RecurseSubmodules {
+		diffFiles = compareGitFolderSubmodules(repoPath, diffFiles, opts)
+	}
+	return diffFiles, nil
+}
+
+// detectGitFolderCopies pairs the added files with the most similar file of the first tree, which may have been
+// modified or left untouched. Files are similar from the threshold in percent, or only when identical without
+// threshold or when the first tree has more than copyDetectionLimit files.
+// Returns the copies by the path of the added file.
+func detectGitFolderCopies(tree *object.Tree, changes object.Changes, threshold int) (map[string]*object.Change, error) {
+	var added object.Changes
+	for _, change := range changes {
+		if change.From.Name == "" {
+			added = append(added, change)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	// Every file of the first tree is a source, as deleted files are
+	var sources object.Changes
+	err := tree.Files().ForEach(func(file *object.File) error {
+		sources = append(sources, &object.Change{From: object.ChangeEntry{
+			Name:      file.Name,
+			Tree:      tree,
+			TreeEntry: object.TreeEntry{Name: path.Base(file.Name), Mode: file.Mode, Hash: file.Hash},
+		}})
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not get tree for commit %s: %v", sha2, err)
+		return nil, err
+	}
+
+	opts := &object.DiffTreeOptions{
+		DetectRenames:    true,
+		RenameScore:      uint(threshold),
+		OnlyExactRenames: threshold == 0 || len(sources) > copyDetectionLimit,
 	}
 
-	// Get the diff between the two trees
-	changes, err := object.DiffTree(tree1, tree2)
+	// A source is paired with a single added file per pass, so pair the remaining ones until none matches
+	copies := make(map[string]*object.Change)
+	for len(added) > 0 {
+		detected, err := object.DetectRenames(append(slices.Clone(sources), added...), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var remaining object.Changes
+		for _, change := range detected {
+			// Unpaired sources have no name in the second tree
+			switch {
+			case change.From.Name == "":
+				remaining = append(remaining, change)
+			case change.To.Name != "":
+				copies[change.To.Name] = change
+			}
+		}
+		if len(remaining) == len(added) {
+			break
+		}
+		added = remaining
+	}
+	return copies, nil
+}
+
+// newGitFolderFileChange describes the change of a file between two trees.
+func newGitFolderFileChange(change *object.Change) FileChange {
+	from, to := change.From.TreeEntry, change.To.TreeEntry
+	fileChange := FileChange{
+		Path:    change.To.Name,
+		OldMode: formatFileMode(from.Mode),
+		NewMode: formatFileMode(to.Mode),
+	}
+	if !from.Hash.IsZero() {
+		fileChange.OldHash = from.Hash.String()
+	}
+	if !to.Hash.IsZero() {
+		fileChange.NewHash = to.Hash.String()
+	}
+
+	switch {
+	case change.From.Name == "":
+		fileChange.Status = FileAdded
+	case change.To.Name == "":
+		// A deleted file has no name in the second tree
+		fileChange.Path = change.From.Name
+		fileChange.Status = FileDeleted
+	case change.From.Name != change.To.Name:
+		fileChange.PreviousPath = change.From.Name
+		fileChange.Status = FileRenamed
+	case !isSameFileType(from.Mode, to.Mode):
+		fileChange.Status = FileTypeChanged
+	default:
+		fileChange.Status = FileModified
+	}
+	return fileChange
+}
+
+// setGitFolderDiff counts the lines added and deleted by the change, like the compare API does, and sets the
+// unified diff of the file when withPatch is set. Binary files and submodules have no lines.
+func setGitFolderDiff(fileChange *FileChange, change *object.Change, withPatch bool) error {
+	patch, err := change.Patch()
 	if err != nil {
-		return nil, fmt.Errorf("could not get diff between trees: %v", err)
+		return err
+	}
+	for _, stat := range patch.Stats() {
+		fileChange.Additions += stat.Addition
+		fileChange.Deletions += stat.Deletion
 	}
+	fileChange.Changes = fileChange.Additions + fileChange.Deletions
 
-	// Collect the filenames of changed files
-	var diffFiles []string
-	for _, change := range changes {
-		// Append the file name (NewName) to the list of diff files
-		diffFiles = append(diffFiles, change.To.Name)
+	if withPatch {
+		var sb strings.Builder
+		if err := patch.Encode(&sb); err != nil {
+			return err
+		}
+		fileChange.Patch = sb.String()
+		// The encoder only knows about renames
+		if fileChange.Status == FileCopied {
+			fileChange.Patch = strings.Replace(fileChange.Patch, "\nrename from ", "\ncopy from ", 1)
+			fileChange.Patch = strings.Replace(fileChange.Patch, "\nrename to ", "\ncopy to ", 1)
+		}
 	}
+	return nil
+}
 
-	return diffFiles, nil
+// isSameFileType reports whether both modes are of the same file type, regular files being of the same type
+// whether they are executable or not.
+func isSameFileType(mode1, mode2 filemode.FileMode) bool {
+	isRegular := func(mode filemode.FileMode) bool {
+		return mode == filemode.Regular || mode == filemode.Executable || mode == filemode.Deprecated
+	}
+	return mode1 == mode2 || (isRegular(mode1) && isRegular(mode2))
+}
+
+// getGitFolderTree retrieves the tree of the commit identified by a revision, or an empty tree for EmptyTreeSHA.
+func getGitFolderTree(repo *git.Repository, sha string) (*object.Tree, error) {
+	if sha == EmptyTreeSHA {
+		return &object.Tree{}, nil
+	}
+
+	// Get the commit corresponding to the given revision
+	commit, err := getGitFolderCommit(repo, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the tree object for the commit
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("could not get tree for commit %s: %v", sha, err)
+	}
+	return tree, nil
+}
+
+// withShallowHint adds a hint to a missing commit error of a shallow clone, as the commit may be cut off.
+func withShallowHint(repo *git.Repository, err error) error {
+	if shallows, _ := repo.Storer.Shallow(); len(shallows) > 0 {
+		return fmt.Errorf("%v (the repository is a shallow clone, set deepen_max_depth or fetch-depth: 0 to fetch the missing history)", err)
+	}
+	return err
 }
 
 // GetGitFolderBranchLatestSHA retrieves the latest commit of a given branch in a Git repository.
-// It takes the repository path and the branch name as input parameters.
-// Returns the commit hash as a string and an error if any occurs.
+// It takes the repository path and the branch name as input parameters. The branch is looked up in the
+// remote-tracking references of origin first, as a CI checkout rarely has local branches, then in the local
+// branches. Loose and packed references are both considered.
+// Returns the commit hash as a string, an empty string if the branch does not exist, and an error if any occurs.
 func GetGitFolderBranchLatestSHA(repoPath, branchName string) (string, error) {
 	// Open the repository at the given path
 	repo, err := git.PlainOpen(repoPath)
@@ -66,18 +264,305 @@ func GetGitFolderBranchLatestSHA(repoPath, branchName string) (string, error) {
 		return "", fmt.Errorf("could not open repository: %v", err)
 	}
 
-	// Get the reference for the specified branch
-	ref, err := repo.Reference(plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branchName)), true)
+	refNames := []plumbing.ReferenceName{
+		plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branchName),
+		plumbing.NewBranchReferenceName(branchName),
+	}
+	for _, refName := range refNames {
+		// Get the reference for the specified branch
+		ref, err := repo.Reference(refName, true)
+		if err == plumbing.ErrReferenceNotFound {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not get reference %s: %v", refName, err)
+		}
+
+		// Get the commit object for the reference
+		commit, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return "", fmt.Errorf("could not get commit object for branch %s: %v", branchName, err)
+		}
+
+		// Return the commit hash as a string
+		return commit.Hash.String(), nil
+	}
+
+	return "", nil
+}
+
+// GetGitFolderCommitSHA resolves a commit in a Git repository to its full SHA.
+// It takes the repository path and the commit (any revision expression, e.g. an abbreviated SHA, HEAD~5,
+// main@{upstream} or v1.2.0^{commit}) as input parameters.
+// Returns the commit hash as a string and an error if any occurs.
+func GetGitFolderCommitSHA(repoPath, commit string) (string, error) {
+	// Open the repository at the given path
+	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
-		return "", fmt.Errorf("could not find reference for branch %s: %v", branchName, err)
+		return "", fmt.Errorf("could not open repository: %v", err)
 	}
 
-	// Get the commit object for the reference
-	commit, err := repo.CommitObject(ref.Hash())
+	// Resolve the commit to a hash
+	hash, err := resolveGitFolderRevision(repo, commit)
 	if err != nil {
-		return "", fmt.Errorf("could not get commit object for branch %s: %v", branchName, err)
+		return "", err
 	}
 
 	// Return the commit hash as a string
-	return commit.Hash.String(), nil
+	return hash.String(), nil
+}
+
+// resolveGitFolderRevision resolves a revision expression to a commit hash. Unlike ResolveRevision, the upstream
+// of a branch, e.g. main@{upstream} or @{u}, resolves to the remote-tracking branch the branch merges from.
+func resolveGitFolderRevision(repo *git.Repository, rev string) (*plumbing.Hash, error) {
+	name, suffix := splitRevision(rev)
+	if branch, ok := parseUpstream(name); ok {
+		upstream, err := getGitFolderUpstream(repo, branch)
+		if err != nil {
+			return nil, err
+		}
+		name = upstream.String()
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(name + suffix))
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve revision %s: %w", rev, err)
+	}
+	return hash, nil
+}
+
+// getGitFolderUpstream returns the reference a branch merges from, as configured in the repository, or the
+// branch on the origin remote otherwise. An empty branch stands for the checked out branch.
+func getGitFolderUpstream(repo *git.Repository, branch string) (plumbing.ReferenceName, error) {
+	if branch == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return "", fmt.Errorf("could not get HEAD: %v", err)
+		}
+		if !head.Name().IsBranch() {
+			return "", fmt.Errorf("HEAD is detached and has no upstream")
+		}
+		branch = head.Name().Short()
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return "", fmt.Errorf("could not read repository config: %v", err)
+	}
+	if b, ok := cfg.Branches[branch]; ok && b.Remote != "" && b.Merge != "" {
+		// A branch tracking a local branch has "." as remote
+		if b.Remote == "." {
+			return b.Merge, nil
+		}
+		return plumbing.NewRemoteReferenceName(b.Remote, b.Merge.Short()), nil
+	}
+	return plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch), nil
+}
+
+// getGitFolderCommit retrieves the commit identified by a revision expression, e.g. a full SHA.
+func getGitFolderCommit(repo *git.Repository, rev string) (*object.Commit, error) {
+	hash, err := resolveGitFolderRevision(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("could not find commit for %s: %v", rev, err)
+	}
+	return commit, nil
+}
+
+// GetGitFolderParentSHA retrieves the first parent of a commit identified by its SHA.
+// It takes the repository path and the commit SHA as input parameters.
+// Returns an empty string for a root commit and an error if any occurs.
+func GetGitFolderParentSHA(repoPath, sha string) (string, error) {
+	// Open the repository at the given path
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("could not open repository: %v", err)
+	}
+
+	// Get the commit corresponding to the given SHA
+	commit, err := getGitFolderCommit(repo, sha)
+	if err != nil {
+		return "", err
+	}
+
+	if commit.NumParents() == 0 {
+		return "", nil
+	}
+	return commit.ParentHashes[0].String(), nil
+}
+
+// GetGitFolderMergeBase retrieves the best common ancestor of two commits identified by their SHAs.
+// It takes the repository path and the two commit SHAs as input parameters.
+// Returns the merge base commit hash as a string and an error if any occurs.
+func GetGitFolderMergeBase(repoPath, sha1, sha2 string) (string, error) {
+	// Open the repository at the given path
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("could not open repository: %v", err)
+	}
+
+	// Get the commits corresponding to the given SHAs
+	commit1, err := getGitFolderCommit(repo, sha1)
+	if err != nil {
+		return "", err
+	}
+
+	commit2, err := getGitFolderCommit(repo, sha2)
+	if err != nil {
+		return "", err
+	}
+
+	// Find the common ancestors of both commits
+	bases, err := commit1.MergeBase(commit2)
+	if err != nil {
+		return "", fmt.Errorf("could not get merge base between %s and %s: %v", sha1, sha2, err)
+	}
+	if len(bases) == 0 {
+		return "", fmt.Errorf("no merge base found between %s and %s", sha1, sha2)
+	}
+
+	// Return the merge base hash as a string
+	return bases[0].Hash.String(), nil
+}
+
+// IsGitFolderAncestor reports whether the first commit is an ancestor of, or the same as, the second commit.
+// It takes the repository path and the two commit SHAs as input parameters.
+// Returns an error if any of the commits cannot be found.
+func IsGitFolderAncestor(repoPath, sha1, sha2 string) (bool, error) {
+	// Open the repository at the given path
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("could not open repository: %v", err)
+	}
+
+	// Get the commits corresponding to the given SHAs
+	commit1, err := getGitFolderCommit(repo, sha1)
+	if err != nil {
+		return false, err
+	}
+
+	commit2, err := getGitFolderCommit(repo, sha2)
+	if err != nil {
+		return false, err
+	}
+
+	return commit1.IsAncestor(commit2)
+}
+
+// GetGitFolderLatestTagSHA retrieves the commit of the highest semantic version tag matching the pattern
+// that is an ancestor of the head commit. Prereleases are skipped when skipPrereleases is set.
+// Returns an empty string if no tag matches and an error if any occurs.
+func GetGitFolderLatestTagSHA(repoPath, pattern, headSha string, skipPrereleases bool) (string, error) {
+	// Open the repository at the given path
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("could not open repository: %v", err)
+	}
+
+	headCommit, err := getGitFolderCommit(repo, headSha)
+	if err != nil {
+		return "", err
+	}
+
+	// Collect the names of all tags
+	refs, err := repo.Tags()
+	if err != nil {
+		return "", fmt.Errorf("could not list tags: %v", err)
+	}
+	var tags []string
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		tags = append(tags, ref.Name().Short())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not list tags: %v", err)
+	}
+
+	// Return the first tag by version that is reachable from the head commit
+	for _, tag := range SortTagsByVersion(tags, pattern, skipPrereleases) {
+		// Resolve the tag to its commit, peeling annotated tags. Tags of trees or blobs are skipped.
+		hash, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewTagReferenceName(tag)))
+		if err != nil {
+			log.Printf("Skipping tag %s, could not resolve it: %v", tag, err)
+			continue
+		}
+
+		commit, err := repo.CommitObject(*hash)
+		if err != nil {
+			log.Printf("Skipping tag %s, could not find its commit: %v", tag, err)
+			continue
+		}
+
+		isAncestor, err := commit.IsAncestor(headCommit)
+		if err != nil {
+			return "", fmt.Errorf("could not check tag %s is an ancestor of %s: %v", tag, headSha, err)
+		}
+		if isAncestor {
+			return hash.String(), nil
+		}
+	}
+
+	return "", nil
+}
+
+// GetGitFolderRefSHA retrieves the commit a reference points to in a Git repository, e.g. refs/deployments/prod.
+// It takes the repository path and the full reference name as input parameters.
+// Returns an empty string if the reference does not exist and an error if any other error occurs.
+func GetGitFolderRefSHA(repoPath, refName string) (string, error) {
+	// Open the repository at the given path
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("could not open repository: %v", err)
+	}
+
+	// Get the reference, following symbolic references
+	ref, err := repo.Reference(plumbing.ReferenceName(refName), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not get reference %s: %v", refName, err)
+	}
+
+	// Return the commit hash as a string
+	return ref.Hash().String(), nil
+}
+
+// SetGitFolderRef points a reference to a commit in a Git repository, creating the reference if needed.
+// When push is set, the reference is pushed to the origin remote, authenticated with the token if given.
+// Returns an error if any occurs.
+func SetGitFolderRef(repoPath, refName, sha string, push bool, token string) error {
+	// Open the repository at the given path
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("could not open repository: %v", err)
+	}
+
+	// Check the commit exists before pointing the reference to it
+	commit, err := getGitFolderCommit(repo, sha)
+	if err != nil {
+		return err
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(refName), commit.Hash)); err != nil {
+		return fmt.Errorf("could not set reference %s: %v", refName, err)
+	}
+
+	if !push {
+		return nil
+	}
+
+	// Force push the reference as it moves back on rollbacks
+	opts := &git.PushOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", refName, refName))},
+		Auth:       gitFolderAuth(token),
+	}
+	if err := repo.Push(opts); err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("could not push reference %s: %v", refName, err)
+	}
+	return nil
 }
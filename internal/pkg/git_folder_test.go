@@ -1,16 +1,130 @@
 package internal
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
+// testCommitCount counts the commits created by addTestCommit to space out their author times
+var testCommitCount atomic.Int64
+
+// newTestRepo creates a Git repository in a temporary folder with one commit for each
+// of the given file sets. A file with empty content is removed in that commit.
+// Returns the repository path and the commit SHAs in order.
+func newTestRepo(t *testing.T, commits ...map[string]string) (string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	if _, err := git.PlainInit(dir, false); err != nil {
+		t.Fatalf("Error initialising repository: %v", err)
+	}
+
+	var shas []string
+	for _, files := range commits {
+		shas = append(shas, addTestCommit(t, dir, files))
+	}
+	return dir, shas
+}
+
+// addTestCommit commits the given files on top of the checked out commit of the test repository.
+// A file with empty content is removed in that commit. Returns the new commit SHA.
+func addTestCommit(t *testing.T, repoPath string, files map[string]string) string {
+	t.Helper()
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Error getting worktree: %v", err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(repoPath, name)
+		if content == "" {
+			if _, err := wt.Remove(name); err != nil {
+				t.Fatalf("Error removing %s: %v", name, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating folder for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatalf("Error adding %s: %v", name, err)
+		}
+	}
+
+	// Space the commits out in time so that the history order is stable
+	when := time.Unix(1700000000+testCommitCount.Add(1)*60, 0)
+	hash, err := wt.Commit("commit", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author: &object.Signature{
+			Name:  "test",
+			Email: "test@example.com",
+			When:  when,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	return hash.String()
+}
+
+// addTestTag tags the given commit of the test repository, with an annotated tag when annotated is set.
+func addTestTag(t *testing.T, repoPath, name, sha string, annotated bool) {
+	t.Helper()
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	var opts *git.CreateTagOptions
+	if annotated {
+		opts = &git.CreateTagOptions{
+			Message: name,
+			Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(1700000000, 0)},
+		}
+	}
+	if _, err := repo.CreateTag(name, plumbing.NewHash(sha), opts); err != nil {
+		t.Fatalf("Error creating tag %s: %v", name, err)
+	}
+}
+
+// checkoutTestCommit checks out the given commit of the test repository in detached mode.
+func checkoutTestCommit(t *testing.T, repoPath, sha string) {
+	t.Helper()
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Error getting worktree: %v", err)
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(sha), Force: true}); err != nil {
+		t.Fatalf("Error checking out %s: %v", sha, err)
+	}
+}
+
 func TestCompareGitFolderSHAs(t *testing.T) {
 	t.Parallel()
 	sha1 := "c6023e778dac2c67e7ec0c42889e349a76414294"
 	sha2 := "839bc7c55038951cfd3fed884617fd80d02ddbd5"
 
-	result, err := CompareGitFolderSHAs("../../", sha1, sha2)
+	result, err := CompareGitFolderSHAs("../../", sha1, sha2, CompareOptions{})
 	if err != nil {
 		t.Fatalf("Error getting diff between commits: %v", err)
 	}
@@ -21,20 +135,582 @@ func TestCompareGitFolderSHAs(t *testing.T) {
 
 	expectedDiff := []string{"Dockerfile", "go.mod", "go.sum"}
 
-	if !reflect.DeepEqual(result, expectedDiff) {
+	if !reflect.DeepEqual(FileChangePaths(result), expectedDiff) {
 		t.Errorf("CompareGitFolderSHAs() = %v, want %v", result, expectedDiff)
 	}
 }
 
-// func TestGetGitFolderBranchLatestSHA(t *testing.T) {
-// 	t.Parallel()
-// 	branch := "main"
-// 	result, err := GetGitFolderBranchLatestSHA("../../", branch)
-// 	if err != nil {
-// 		t.Fatalf("Error getting latest sha on %v: %v", branch, err)
-// 	}
+func TestGetGitFolderCommitSHA(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+		map[string]string{"c.txt": "c"},
+	)
+	addTestTag(t, repoPath, "v1.2.0", shas[1], true)
+
+	// master tracks origin/main, feature has no tracking configuration
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	repoConfig, err := repo.Config()
+	if err != nil {
+		t.Fatalf("Error reading config: %v", err)
+	}
+	repoConfig.Branches["master"] = &config.Branch{Name: "master", Remote: "origin", Merge: plumbing.NewBranchReferenceName("main")}
+	if err := repo.SetConfig(repoConfig); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+	for name, sha := range map[plumbing.ReferenceName]string{
+		plumbing.NewRemoteReferenceName("origin", "main"):    shas[0],
+		plumbing.NewRemoteReferenceName("origin", "feature"): shas[1],
+	} {
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(name, plumbing.NewHash(sha))); err != nil {
+			t.Fatalf("Error setting reference %s: %v", name, err)
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		commit        string
+		expectedSHA   string
+		expectedError bool
+	}{
+		{
+			name:        "Full SHA",
+			commit:      shas[0],
+			expectedSHA: shas[0],
+		},
+		{
+			name:        "Abbreviated SHA",
+			commit:      shas[1][:7],
+			expectedSHA: shas[1],
+		},
+		{
+			name:        "HEAD",
+			commit:      "HEAD",
+			expectedSHA: shas[2],
+		},
+		{
+			name:        "Ancestor of HEAD",
+			commit:      "HEAD~2",
+			expectedSHA: shas[0],
+		},
+		{
+			name:        "Parent of an abbreviated SHA",
+			commit:      shas[2][:7] + "^",
+			expectedSHA: shas[1],
+		},
+		{
+			name:        "Annotated tag peeled to its commit",
+			commit:      "v1.2.0^{commit}",
+			expectedSHA: shas[1],
+		},
+		{
+			name:        "Configured upstream",
+			commit:      "master@{upstream}",
+			expectedSHA: shas[0],
+		},
+		{
+			name:        "Upstream of the checked out branch",
+			commit:      "@{u}",
+			expectedSHA: shas[0],
+		},
+		{
+			name:        "Upstream on origin without configuration",
+			commit:      "feature@{upstream}~1",
+			expectedSHA: shas[0],
+		},
+		{
+			name:          "Ancestor beyond the root commit",
+			commit:        "HEAD~3",
+			expectedError: true,
+		},
+		{
+			name:          "Unknown commit",
+			commit:        "0000000000000000000000000000000000000001",
+			expectedError: true,
+		},
+	}
 
-// 	if result == "" {
-// 		t.Fatal("Expected non-nil result, got nil")
-// 	}
-// }
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sha, err := GetGitFolderCommitSHA(repoPath, tc.commit)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sha != tc.expectedSHA {
+				t.Errorf("GetGitFolderCommitSHA(%q) = %s, want %s", tc.commit, sha, tc.expectedSHA)
+			}
+		})
+	}
+}
+
+func TestCompareGitFolderFileChanges(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"modified.txt": "a", "deleted.txt": "b", "link": "c", "script.sh": "d"},
+	)
+
+	// Replace a file with a symlink and make a script executable
+	if err := os.Remove(filepath.Join(repoPath, "link")); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+	if err := os.Symlink("modified.txt", filepath.Join(repoPath, "link")); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(repoPath, "script.sh"), 0755); err != nil {
+		t.Fatalf("Error changing mode: %v", err)
+	}
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Error getting worktree: %v", err)
+	}
+	for _, name := range []string{"link", "script.sh"} {
+		if _, err := wt.Add(name); err != nil {
+			t.Fatalf("Error adding %s: %v", name, err)
+		}
+	}
+	head := addTestCommit(t, repoPath, map[string]string{"modified.txt": "a v2", "deleted.txt": "", "added.txt": "e"})
+
+	result, err := CompareGitFolderSHAs(repoPath, shas[0], head, CompareOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	byPath := make(map[string]FileChange)
+	for _, change := range result {
+		byPath[change.Path] = change
+	}
+	expected := map[string]FileStatus{
+		"added.txt":    FileAdded,
+		"deleted.txt":  FileDeleted,
+		"link":         FileTypeChanged,
+		"modified.txt": FileModified,
+		"script.sh":    FileModified,
+	}
+	if len(byPath) != len(expected) {
+		t.Errorf("CompareGitFolderSHAs() = %v, want changes of %v", result, expected)
+	}
+	for path, status := range expected {
+		if byPath[path].Status != status {
+			t.Errorf("Status of %s = %s, want %s", path, byPath[path].Status, status)
+		}
+	}
+
+	// Hashes and modes are set for the sides where the file exists
+	if c := byPath["added.txt"]; c.OldHash != "" || c.OldMode != "" || c.NewHash == "" || c.NewMode != "100644" {
+		t.Errorf("Unexpected added file change %+v", c)
+	}
+	if c := byPath["deleted.txt"]; c.OldHash == "" || c.OldMode != "100644" || c.NewHash != "" || c.NewMode != "" {
+		t.Errorf("Unexpected deleted file change %+v", c)
+	}
+	if c := byPath["link"]; c.OldMode != "100644" || c.NewMode != "120000" {
+		t.Errorf("Unexpected type changed file change %+v", c)
+	}
+	if c := byPath["script.sh"]; c.OldMode != "100644" || c.NewMode != "100755" || c.OldHash != c.NewHash {
+		t.Errorf("Unexpected mode changed file change %+v", c)
+	}
+}
+
+func TestCompareGitFolderRenames(t *testing.T) {
+	t.Parallel()
+	mainTf := "resource \"a\" \"b\" {\n  name = \"prod\"\n  size = 1\n  tags = {}\n}\n"
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"live/prod/main.tf": mainTf, "shared.txt": "line 1\nline 2\nline 3\nline 4\n"},
+	)
+	// Move the folder with a small edit, and copy a file that stays untouched
+	head := addTestCommit(t, repoPath, map[string]string{
+		"live/prod/main.tf":    "",
+		"modules/prod/main.tf": strings.Replace(mainTf, "size = 1", "size = 2", 1),
+		"copy.txt":             "line 1\nline 2\nline 3\nline 4\n",
+	})
+
+	testCases := []struct {
+		name     string
+		opts     CompareOptions
+		expected []FileChange
+	}{
+		{
+			name: "Without rename detection",
+			opts: CompareOptions{},
+			expected: []FileChange{
+				{Path: "copy.txt", Status: FileAdded},
+				{Path: "live/prod/main.tf", Status: FileDeleted},
+				{Path: "modules/prod/main.tf", Status: FileAdded},
+			},
+		},
+		{
+			name: "Renames",
+			opts: CompareOptions{RenameThreshold: 50},
+			expected: []FileChange{
+				{Path: "copy.txt", Status: FileAdded},
+				{Path: "modules/prod/main.tf", PreviousPath: "live/prod/main.tf", Status: FileRenamed},
+			},
+		},
+		{
+			name: "Renames above the threshold only",
+			opts: CompareOptions{RenameThreshold: 100},
+			expected: []FileChange{
+				{Path: "copy.txt", Status: FileAdded},
+				{Path: "live/prod/main.tf", Status: FileDeleted},
+				{Path: "modules/prod/main.tf", Status: FileAdded},
+			},
+		},
+		{
+			name: "Renames and copies",
+			opts: CompareOptions{RenameThreshold: 50, DetectCopies: true},
+			expected: []FileChange{
+				{Path: "copy.txt", PreviousPath: "shared.txt", Status: FileCopied},
+				{Path: "modules/prod/main.tf", PreviousPath: "live/prod/main.tf", Status: FileRenamed},
+			},
+		},
+		{
+			name: "Includes",
+			opts: CompareOptions{IncludePatterns: []string{"live/**"}},
+			expected: []FileChange{
+				{Path: "live/prod/main.tf", Status: FileDeleted},
+			},
+		},
+		{
+			name: "Includes matching the previous path of a rename",
+			opts: CompareOptions{RenameThreshold: 50, IncludePatterns: []string{"live/**"}},
+			expected: []FileChange{
+				{Path: "modules/prod/main.tf", PreviousPath: "live/prod/main.tf", Status: FileRenamed},
+			},
+		},
+		{
+			name: "Excludes",
+			opts: CompareOptions{ExcludePatterns: []string{"**/*.tf"}},
+			expected: []FileChange{
+				{Path: "copy.txt", Status: FileAdded},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			result, err := CompareGitFolderSHAs(repoPath, shas[0], head, tc.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// Compare the paths and statuses only
+			var changes []FileChange
+			for _, change := range result {
+				changes = append(changes, FileChange{Path: change.Path, PreviousPath: change.PreviousPath, Status: change.Status})
+			}
+			sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+			if !reflect.DeepEqual(changes, tc.expected) {
+				t.Errorf("CompareGitFolderSHAs() = %v, want %v", changes, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCompareGitFolderLineStats(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "1\n2\n3\n", "b.txt": "1\n2\n", "c.bin": "\x00\x01"},
+	)
+	head := addTestCommit(t, repoPath, map[string]string{"a.txt": "1\nX\n3\n4\n", "b.txt": "", "c.bin": "\x00\x02"})
+
+	result, err := CompareGitFolderSHAs(repoPath, shas[0], head, CompareOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Binary files have no lines
+	expected := map[string][3]int{"a.txt": {2, 1, 3}, "b.txt": {0, 2, 2}, "c.bin": {0, 0, 0}}
+	for _, change := range result {
+		stats := [3]int{change.Additions, change.Deletions, change.Changes}
+		if stats != expected[change.Path] {
+			t.Errorf("Line stats of %s = %v, want %v", change.Path, stats, expected[change.Path])
+		}
+	}
+	if len(result) != len(expected) {
+		t.Errorf("CompareGitFolderSHAs() = %v, want changes of %v", result, expected)
+	}
+}
+
+func TestCompareGitFolderPatches(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "1\n2\n", "b.txt": "b\n", "shared.txt": "line 1\nline 2\nline 3\n"},
+	)
+	head := addTestCommit(t, repoPath, map[string]string{"a.txt": "1\n3\n", "b.txt": "", "copy.txt": "line 1\nline 2\nline 3\n"})
+
+	// Without the option, no patch is set
+	result, err := CompareGitFolderSHAs(repoPath, shas[0], head, CompareOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, change := range result {
+		if change.Patch != "" {
+			t.Errorf("Expected no patch for %s, got %q", change.Path, change.Patch)
+		}
+	}
+
+	result, err = CompareGitFolderSHAs(repoPath, shas[0], head, CompareOptions{RenameThreshold: 50, DetectCopies: true, Patches: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string][]string{
+		"a.txt":    {"diff --git a/a.txt b/a.txt\n", "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n 1\n-2\n+3\n"},
+		"b.txt":    {"diff --git a/b.txt b/b.txt\ndeleted file mode 100644\n", "--- a/b.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-b\n"},
+		"copy.txt": {"diff --git a/shared.txt b/copy.txt\ncopy from shared.txt\ncopy to copy.txt\n"},
+	}
+	for _, change := range result {
+		for _, part := range expected[change.Path] {
+			if !strings.Contains(change.Patch, part) {
+				t.Errorf("Patch of %s = %q, want it to contain %q", change.Path, change.Patch, part)
+			}
+		}
+	}
+	if len(result) != len(expected) {
+		t.Errorf("CompareGitFolderSHAs() = %v, want changes of %v", result, expected)
+	}
+}
+
+func TestCompareGitFolderRevisions(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+		map[string]string{"c.txt": "c"},
+	)
+
+	// Revisions other than full SHAs are resolved rather than read as zero hashes
+	result, err := CompareGitFolderSHAs(repoPath, "HEAD~2", shas[2][:7], CompareOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"b.txt", "c.txt"}
+	if !reflect.DeepEqual(FileChangePaths(result), expected) {
+		t.Errorf("CompareGitFolderSHAs() = %v, want %v", result, expected)
+	}
+
+	if _, err := CompareGitFolderSHAs(repoPath, "unknown", "HEAD", CompareOptions{}); err == nil {
+		t.Errorf("Expected an error, but got nil")
+	}
+}
+
+func TestGetGitFolderMergeBase(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+		map[string]string{"c.txt": "c"},
+	)
+
+	mergeBase, err := GetGitFolderMergeBase(repoPath, shas[0], shas[2])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mergeBase != shas[0] {
+		t.Errorf("GetGitFolderMergeBase() = %s, want %s", mergeBase, shas[0])
+	}
+
+	if _, err := GetGitFolderMergeBase(repoPath, "0000000000000000000000000000000000000001", shas[2]); err == nil {
+		t.Errorf("Expected an error, but got nil")
+	}
+}
+
+func TestGetGitFolderLatestTagSHA(t *testing.T) {
+	t.Parallel()
+	// main: c0 -> c1 -> c2 -> c3, other: c1 -> c4
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+		map[string]string{"c.txt": "c"},
+		map[string]string{"d.txt": "d"},
+	)
+	checkoutTestCommit(t, repoPath, shas[1])
+	other := addTestCommit(t, repoPath, map[string]string{"e.txt": "e"})
+
+	addTestTag(t, repoPath, "v1.0.0", shas[0], false)
+	addTestTag(t, repoPath, "v1.1.0", shas[1], true)
+	addTestTag(t, repoPath, "v1.2.0-rc.1", shas[2], true)
+	addTestTag(t, repoPath, "v9.0.0", other, false)
+	addTestTag(t, repoPath, "service-a/v2.0.0", shas[0], true)
+
+	// A tag of a tree is skipped
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	headCommit, err := repo.CommitObject(plumbing.NewHash(shas[3]))
+	if err != nil {
+		t.Fatalf("Error getting commit: %v", err)
+	}
+	addTestTag(t, repoPath, "v5.0.0", headCommit.TreeHash.String(), true)
+
+	testCases := []struct {
+		name            string
+		pattern         string
+		head            string
+		skipPrereleases bool
+		expectedSHA     string
+	}{
+		{
+			name:        "Highest ancestor version including prereleases",
+			pattern:     "v*",
+			head:        shas[3],
+			expectedSHA: shas[2],
+		},
+		{
+			name:            "Highest ancestor version skipping prereleases",
+			pattern:         "v*",
+			head:            shas[3],
+			skipPrereleases: true,
+			expectedSHA:     shas[1],
+		},
+		{
+			name:        "Tags on other branches only match their own history",
+			pattern:     "v*",
+			head:        other,
+			expectedSHA: other,
+		},
+		{
+			name:        "Component tags",
+			pattern:     "service-a/v*",
+			head:        shas[3],
+			expectedSHA: shas[0],
+		},
+		{
+			name:        "No matching tag",
+			pattern:     "service-b/v*",
+			head:        shas[3],
+			expectedSHA: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sha, err := GetGitFolderLatestTagSHA(repoPath, tc.pattern, tc.head, tc.skipPrereleases)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sha != tc.expectedSHA {
+				t.Errorf("GetGitFolderLatestTagSHA(%q) = %s, want %s", tc.pattern, sha, tc.expectedSHA)
+			}
+		})
+	}
+}
+
+func TestSetAndGetGitFolderRef(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+	)
+
+	// Use a local bare repository as the origin remote
+	remotePath := t.TempDir()
+	if _, err := git.PlainInit(remotePath, true); err != nil {
+		t.Fatalf("Error initialising remote repository: %v", err)
+	}
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remotePath}}); err != nil {
+		t.Fatalf("Error creating remote: %v", err)
+	}
+
+	// A missing reference resolves to an empty SHA
+	sha, err := GetGitFolderRefSHA(repoPath, "refs/deployments/prod")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sha != "" {
+		t.Errorf("Expected empty SHA for missing reference, got %s", sha)
+	}
+
+	// Record a deployment, then move it back as a rollback and push it
+	if err := SetGitFolderRef(repoPath, "refs/deployments/prod", shas[1], true, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := SetGitFolderRef(repoPath, "refs/deployments/prod", shas[0], true, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, path := range []string{repoPath, remotePath} {
+		sha, err := GetGitFolderRefSHA(path, "refs/deployments/prod")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if sha != shas[0] {
+			t.Errorf("GetGitFolderRefSHA(%s) = %s, want %s", path, sha, shas[0])
+		}
+	}
+
+	// Unknown commits cannot be recorded
+	if err := SetGitFolderRef(repoPath, "refs/deployments/prod", "0000000000000000000000000000000000000001", false, ""); err == nil {
+		t.Errorf("Expected an error, but got nil")
+	}
+}
+
+func TestGetGitFolderBranchLatestSHA(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+		map[string]string{"c.txt": "c"},
+	)
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+
+	// A stale local branch is shadowed by the remote-tracking branch
+	for name, sha := range map[plumbing.ReferenceName]string{
+		plumbing.NewBranchReferenceName("main"):           shas[0],
+		plumbing.NewRemoteReferenceName("origin", "main"): shas[1],
+		plumbing.NewBranchReferenceName("feature"):        shas[2],
+	} {
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(name, plumbing.NewHash(sha))); err != nil {
+			t.Fatalf("Error setting reference %s: %v", name, err)
+		}
+	}
+
+	// Packed references only exist in the packed-refs file, e.g. after a fresh clone
+	packedRefs := shas[2] + " refs/remotes/origin/release\n"
+	if err := os.WriteFile(filepath.Join(repoPath, ".git", "packed-refs"), []byte(packedRefs), 0644); err != nil {
+		t.Fatalf("Error writing packed references: %v", err)
+	}
+
+	testCases := []struct {
+		branch   string
+		expected string
+	}{
+		{branch: "main", expected: shas[1]},
+		{branch: "feature", expected: shas[2]},
+		{branch: "release", expected: shas[2]},
+		{branch: "unknown", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.branch, func(t *testing.T) {
+			result, err := GetGitFolderBranchLatestSHA(repoPath, tc.branch)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("GetGitFolderBranchLatestSHA(%s) = %s, want %s", tc.branch, result, tc.expected)
+			}
+		})
+	}
+}
@@ -2,55 +2,279 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/google/go-github/v66/github"
 )
 
-// GetLatestSuccessfulDeploymentSha retrieves the Sha of latest successful deployment for a given environment
-func GetLatestSuccessfulDeploymentSha(client *github.Client, cfg *InputConfig) string {
+// GetLatestSuccessfulDeploymentSha retrieves the Sha of latest successful deployment for a given environment.
+// Only the deployments matching the configured task, ref, creator and payload are considered, and the newest
+// status of the deployment must be one of the accepted states. Returns an empty string when the environment has no
+// successful deployment, and an error when the deployments cannot be listed.
+func GetLatestSuccessfulDeploymentSha(client *github.Client, cfg *InputConfig) (string, error) {
+	shas, err := GetLatestSuccessfulDeploymentShas(client, cfg, []string{cfg.Environment})
+	return shas[cfg.Environment], err
+}
+
+// GetLatestSuccessfulDeploymentShas retrieves the Sha of latest successful deployment for each of the given
+// environments, listing the deployments of the repository only once. Environments without a successful
+// deployment are left out of the returned map. The same rules as GetLatestSuccessfulDeploymentSha apply.
+func GetLatestSuccessfulDeploymentShas(client *github.Client, cfg *InputConfig, environments []string) (map[string]string, error) {
+	return getSuccessfulDeploymentShas(client, cfg, environments, nil)
+}
+
+// GetPreviousSuccessfulDeploymentSha retrieves the Sha of the latest successful deployment of the environment
+// whose SHA is accepted, e.g. the latest deployment still in the history of the current SHA. The same rules as
+// GetLatestSuccessfulDeploymentSha apply.
+func GetPreviousSuccessfulDeploymentSha(client *github.Client, cfg *InputConfig, accept func(sha string) bool) (string, error) {
+	shas, err := getSuccessfulDeploymentShas(client, cfg, []string{cfg.Environment}, accept)
+	return shas[cfg.Environment], err
+}
+
+// getSuccessfulDeploymentShas retrieves the Sha of the latest successful deployment for each of the given
+// environments, only considering the deployments whose SHA is accepted when accept is set.
+func getSuccessfulDeploymentShas(client *github.Client, cfg *InputConfig, environments []string, accept func(sha string) bool) (map[string]string, error) {
 	// Create a background context for the GitHub API calls
 	ctx := context.Background()
 	// Extract the owner and repository names from the full repository path
 	owner, repo := extractOwnerRepo(cfg.Repo)
 
-	// Config the options to setup the environment and page size
+	// Config the options to setup the task and page size, a single environment is filtered by the API
 	opt := &github.DeploymentsListOptions{
-		Environment: cfg.Environment,
+		Task:        cfg.DeploymentTask,
 		ListOptions: github.ListOptions{PerPage: 50},
 	}
+	if len(environments) == 1 {
+		opt.Environment = environments[0]
+	}
 
+	shas := make(map[string]string)
 	// Find the recently successfully deployment by loop on the pagination
-	for {
+	for len(shas) < len(environments) {
 		// List all deployment by page
 		deployments, resp, err := client.Repositories.ListDeployments(ctx, owner, repo, opt)
 		if err != nil {
-			log.Panicf("Error listing deployments: %v", err)
+			return nil, fmt.Errorf("error listing deployments: %v", err)
 		}
-		// Check if the deployment has a successful state
+		// Collect the deployments of the environments still to resolve that match the configured rules
+		var candidates []*github.Deployment
+		var candidateEnvironments []string
 		for _, deployment := range deployments {
+			environment := deployment.GetEnvironment()
+			if opt.Environment != "" {
+				environment = opt.Environment
+			}
 
-			// Get the statues for the deployment
-			statuses, _, err := client.Repositories.ListDeploymentStatuses(ctx, owner, repo, deployment.GetID(), &github.ListOptions{PerPage: 1})
-			if err != nil {
-				log.Printf("Error getting deployment status: %v", err)
+			// Skip the deployments of other or already resolved environments
+			if _, found := shas[environment]; found || !slices.Contains(environments, environment) {
+				continue
+			}
+
+			// Skip the deployments not matching the configured rules
+			if !matchDeployment(deployment, cfg) {
+				continue
+			}
+			if accept != nil && !accept(deployment.GetSHA()) {
+				log.Printf("Skipping deployment ID %d of %s, SHA %s", deployment.GetID(), environment, deployment.GetSHA())
 				continue
 			}
+			candidates = append(candidates, deployment)
+			candidateEnvironments = append(candidateEnvironments, environment)
+		}
 
-			// move the next deployment if no statues found
-			if len(statuses) == 0 {
-				log.Printf("No deployment status found for deployment ID %d", deployment.GetID())
+		// Check if the deployment has a successful state, newest first
+		states, errs := lookupDeploymentStates(ctx, client, owner, repo, candidates, candidateEnvironments, cfg.AcceptedDeploymentStates(), cfg.DeploymentConcurrency)
+		for i, deployment := range candidates {
+			environment := candidateEnvironments[i]
+			if _, found := shas[environment]; found {
 				continue
 			}
+			// A deployment whose status is unknown may be the latest successful one
+			if errs[i] != nil {
+				return nil, fmt.Errorf("error getting status of deployment ID %d: %v", deployment.GetID(), errs[i])
+			}
+			if slices.Contains(cfg.AcceptedDeploymentStates(), states[i]) {
+				log.Printf("Latest successful deployment for %s: ID %d, SHA %s, state %s", environment, deployment.GetID(), deployment.GetSHA(), states[i])
+				shas[environment] = deployment.GetSHA()
+			}
+		}
+
+		// loop to next page
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	for _, environment := range environments {
+		if _, found := shas[environment]; !found {
+			log.Printf("No successful deployments found for environment: %s", environment)
+		}
+	}
+	return shas, nil
+}
+
+// lookupDeploymentStates retrieves the newest status state of each deployment, running up to concurrency lookups
+// in parallel in the order of the deployments. Once a deployment has an accepted state, the lookups of the later
+// deployments of the same environment are skipped, leaving their state empty. The deployment environments are
+// given in the same order as the deployments. Returns the error of each failed lookup at the index of its
+// deployment.
+func lookupDeploymentStates(ctx context.Context, client *github.Client, owner, repo string, deployments []*github.Deployment, environments, accepted []string, concurrency int) ([]string, []error) {
+	states := make([]string, len(deployments))
+	errs := make([]error, len(deployments))
+	// first holds the index of the first deployment found with an accepted state by environment
+	first := make(map[string]int)
+	var mu sync.Mutex
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				deployment, environment := deployments[i], environments[i]
+
+				// Skip the deployment if a newer one of the environment is already successful
+				mu.Lock()
+				index, found := first[environment]
+				mu.Unlock()
+				if found && index < i {
+					continue
+				}
+
+				// Get the statues for the deployment
+				statuses, _, err := client.Repositories.ListDeploymentStatuses(ctx, owner, repo, deployment.GetID(), &github.ListOptions{PerPage: 1})
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+
+				// move the next deployment if no statues found
+				if len(statuses) == 0 {
+					log.Printf("No deployment status found for deployment ID %d", deployment.GetID())
+					continue
+				}
+
+				state := statuses[0].GetState()
+				mu.Lock()
+				states[i] = state
+				if index, found := first[environment]; slices.Contains(accepted, state) && (!found || i < index) {
+					first[environment] = i
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range deployments {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return states, errs
+}
+
+// matchDeployment checks if the deployment matches the configured ref glob, creator and payload fields.
+// The task is filtered by the deployments API already.
+func matchDeployment(deployment *github.Deployment, cfg *InputConfig) bool {
+	if cfg.DeploymentRef != "" {
+		matched, err := doublestar.Match(cfg.DeploymentRef, deployment.GetRef())
+		if err != nil || !matched {
+			return false
+		}
+	}
+
+	if cfg.DeploymentCreator != "" && deployment.GetCreator().GetLogin() != cfg.DeploymentCreator {
+		return false
+	}
+
+	if len(cfg.DeploymentPayloadFields) > 0 {
+		var payload any
+		if err := json.Unmarshal(deployment.Payload, &payload); err != nil {
+			return false
+		}
+		for path, expected := range cfg.DeploymentPayloadFields {
+			value, ok := lookupJSONPath(payload, path)
+			if !ok || fmt.Sprint(value) != expected {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// lookupJSONPath returns the value at the dot separated path in the decoded JSON data, e.g. "deploy.target"
+// or "targets.0.name" where numbers index into arrays. Returns false if the path does not exist.
+func lookupJSONPath(data any, path string) (any, bool) {
+	if path == "" {
+		return data, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := data.(type) {
+		case map[string]any:
+			value, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			data = value
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			data = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
 
-			// check the status
-			status := statuses[0]
-			if status.GetState() == "success" {
-				log.Printf("Latest successful deployment for %s: ID %d, SHA %s", cfg.Environment, deployment.GetID(), deployment.GetSHA())
-				return deployment.GetSHA()
+// GetLatestSuccessfulWorkflowRunSha retrieves the head SHA of the latest successful run of the current workflow on a
+// branch, listing the runs of the workflow file of GITHUB_WORKFLOW_REF. Returns an empty string when the workflow has
+// no other successful run on the branch, and an error when the runs cannot be listed.
+func GetLatestSuccessfulWorkflowRunSha(client *github.Client, cfg *InputConfig, branch string) (string, error) {
+	// Create a background context for the GitHub API calls
+	ctx := context.Background()
+	// Extract the owner and repository names from the full repository path
+	owner, repo := extractOwnerRepo(cfg.Repo)
+	workflow := cfg.WorkflowFile()
+
+	// Config the options to only list the successful runs on the branch
+	opt := &github.ListWorkflowRunsOptions{
+		Branch:      branch,
+		Status:      "success",
+		ListOptions: github.ListOptions{PerPage: 50},
+	}
+
+	// Find the most recent successful run of the workflow by loop on the pagination
+	for {
+		// List the runs of the workflow by page, newest first
+		runs, resp, err := client.Actions.ListWorkflowRunsByFileName(ctx, owner, repo, workflow, opt)
+		if err != nil {
+			return "", fmt.Errorf("error listing runs of workflow %s: %v", workflow, err)
+		}
+
+		for _, run := range runs.WorkflowRuns {
+			// Skip the current run
+			if strconv.FormatInt(run.GetID(), 10) == cfg.RunID {
+				continue
 			}
+			log.Printf("Latest successful run of workflow %s on branch %s: ID %d, SHA %s", workflow, branch, run.GetID(), run.GetHeadSHA())
+			return run.GetHeadSHA(), nil
 		}
 
 		// loop to next page
@@ -60,12 +284,13 @@ func GetLatestSuccessfulDeploymentSha(client *github.Client, cfg *InputConfig) s
 		opt.Page = resp.NextPage
 	}
 
-	log.Printf("No successful deployments found for environment: %s", cfg.Environment)
-	return ""
+	log.Printf("No successful runs found for workflow %s on branch %s", workflow, branch)
+	return "", nil
 }
 
-// GetLatestSHA retrieves the latest commit SHA for a specified branch in a repository.
-func GetGitHubBranchLatestSHA(client *github.Client, cfg *InputConfig) string {
+// GetGitHubBranchLatestSHA retrieves the latest commit SHA for a specified branch in a repository.
+// Returns an empty string when the branch does not exist, and an error when it cannot be looked up.
+func GetGitHubBranchLatestSHA(client *github.Client, cfg *InputConfig) (string, error) {
 	// Create a background context for the GitHub API calls
 	ctx := context.Background()
 	// Extract the owner and repository names from the full repository path
@@ -73,13 +298,257 @@ func GetGitHubBranchLatestSHA(client *github.Client, cfg *InputConfig) string {
 
 	// Get the reference for the specified branch
 	ref, _, err := client.Git.GetRef(ctx, owner, repo, "refs/heads/"+cfg.Branch)
+	if isGitHubNotFound(err) {
+		log.Printf("Branch '%s' not found in repository '%s'", cfg.Branch, cfg.Repo)
+		return "", nil
+	}
 	if err != nil {
-		log.Printf("Error retrieving SHA for branch '%s' in repository '%s': %v", cfg.Branch, cfg.Repo, err)
-		return ""
+		return "", fmt.Errorf("error retrieving SHA for branch %s: %w", cfg.Branch, err)
 	}
 	log.Printf("Latest successful Sha for Brach %s, SHA %s", cfg.Branch, ref.Object.GetSHA())
 	// Return the SHA of the latest commit
-	return ref.Object.GetSHA()
+	return ref.Object.GetSHA(), nil
+}
+
+// GetGitHubCommitSHA resolves the commit given in the configuration to its full SHA.
+// Returns an empty string when the commit does not exist, and an error when it cannot be looked up.
+func GetGitHubCommitSHA(client *github.Client, cfg *InputConfig) (string, error) {
+	sha, err := GetGitHubRevisionSHA(client, cfg, cfg.Commit)
+	if isGitHubNotFound(err) {
+		log.Printf("Commit '%s' not found in repository '%s'", cfg.Commit, cfg.Repo)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Resolved commit %s to SHA %s", cfg.Commit, sha)
+	return sha, nil
+}
+
+// GetGitHubRevisionSHA resolves a revision expression to its full SHA with the commits API, e.g. an abbreviated
+// SHA, "main~2", "v1.2.0^{commit}" or "HEAD^2". HEAD is the current SHA, and the upstream of a branch is the
+// branch itself as GitHub holds the remote branches.
+func GetGitHubRevisionSHA(client *github.Client, cfg *InputConfig, rev string) (string, error) {
+	// Create a background context for the GitHub API calls
+	ctx := context.Background()
+	// Extract the owner and repository names from the full repository path
+	owner, repo := extractOwnerRepo(cfg.Repo)
+
+	name, suffix := splitRevision(rev)
+	steps, err := parseRevisionSuffix(suffix)
+	if err != nil {
+		return "", err
+	}
+	if branch, ok := parseUpstream(name); ok {
+		name = valueOr(branch, cfg.CurrentBranch())
+	}
+	if name == "HEAD" || name == "@" {
+		name = cfg.Sha
+	}
+	if name == "" {
+		return "", fmt.Errorf("could not resolve revision %s: no starting point", rev)
+	}
+
+	// Resolve the starting point to its full SHA
+	sha, _, err := client.Repositories.GetCommitSHA1(ctx, owner, repo, name, "")
+	if err != nil {
+		return "", fmt.Errorf("error retrieving SHA for %s: %w", name, err)
+	}
+
+	// Walk the parents following the suffix
+	for _, step := range steps {
+		// "~n" follows the first parent n times, "^n" moves to the nth parent once and "^0" stays
+		index, times := 0, step.n
+		if !step.ancestor {
+			index, times = step.n-1, min(step.n, 1)
+		}
+		for range times {
+			parents, err := getGitHubParentSHAs(ctx, client, owner, repo, sha)
+			if err != nil {
+				return "", err
+			}
+			if index >= len(parents) {
+				return "", fmt.Errorf("could not resolve revision %s: %s has %d parents", rev, sha, len(parents))
+			}
+			sha = parents[index]
+		}
+	}
+	return sha, nil
+}
+
+// getGitHubParentSHAs retrieves the parent SHAs of a commit, in order.
+func getGitHubParentSHAs(ctx context.Context, client *github.Client, owner, repo, sha string) ([]string, error) {
+	commit, _, err := client.Git.GetCommit(ctx, owner, repo, sha)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving commit %s: %v", sha, err)
+	}
+
+	parents := make([]string, 0, len(commit.Parents))
+	for _, parent := range commit.Parents {
+		parents = append(parents, parent.GetSHA())
+	}
+	return parents, nil
+}
+
+// GetGitHubMergeBaseSHA retrieves the merge base between the base SHA and the current SHA,
+// as returned by the compare API.
+func GetGitHubMergeBaseSHA(client *github.Client, cfg *InputConfig, baseSHA string) (string, error) {
+	// Create a background context for the GitHub API calls
+	ctx := context.Background()
+	// Extract the owner and repository names from the full repository path
+	owner, repo := extractOwnerRepo(cfg.Repo)
+
+	// Compare the commits between the base SHA and the current SHA
+	comparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, baseSHA, cfg.Sha, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		return "", fmt.Errorf("error comparing commits: %v", err)
+	}
+
+	mergeBase := comparison.GetMergeBaseCommit().GetSHA()
+	log.Printf("Merge base of %s and %s, SHA %s", baseSHA, cfg.Sha, mergeBase)
+	return mergeBase, nil
+}
+
+// GetGitHubParentSHA retrieves the first parent of the current SHA. Returns an empty string for a root commit.
+func GetGitHubParentSHA(client *github.Client, cfg *InputConfig) (string, error) {
+	// Create a background context for the GitHub API calls
+	ctx := context.Background()
+	// Extract the owner and repository names from the full repository path
+	owner, repo := extractOwnerRepo(cfg.Repo)
+
+	parents, err := getGitHubParentSHAs(ctx, client, owner, repo, cfg.Sha)
+	if err != nil || len(parents) == 0 {
+		return "", err
+	}
+	return parents[0], nil
+}
+
+// IsGitHubAncestor reports whether the commit is an ancestor of, or the same as, the current SHA.
+func IsGitHubAncestor(client *github.Client, cfg *InputConfig, sha string) (bool, error) {
+	// Create a background context for the GitHub API calls
+	ctx := context.Background()
+	// Extract the owner and repository names from the full repository path
+	owner, repo := extractOwnerRepo(cfg.Repo)
+
+	// Compare the commits between the SHA and the current SHA
+	comparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, sha, cfg.Sha, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		return false, fmt.Errorf("error comparing commits: %w", err)
+	}
+
+	// The current SHA is ahead of its ancestors
+	status := comparison.GetStatus()
+	return status == "ahead" || status == "identical", nil
+}
+
+// GetGitHubLatestTagSHA retrieves the commit SHA of the highest semantic version tag matching the pattern that
+// is an ancestor of the current SHA. The tags are listed from the releases when the base tag source is releases,
+// skipping draft releases, otherwise from the tags of the repository. Prereleases are skipped when configured.
+// Returns an empty string when no tag matches, skipping the tags that do not resolve to a commit, and an error when
+// the tags cannot be listed or checked.
+func GetGitHubLatestTagSHA(client *github.Client, cfg *InputConfig, pattern string) (string, error) {
+	// Create a background context for the GitHub API calls
+	ctx := context.Background()
+	// Extract the owner and repository names from the full repository path
+	owner, repo := extractOwnerRepo(cfg.Repo)
+
+	var tags []string
+	var err error
+	if cfg.BaseTagSource == "releases" {
+		tags, err = listGitHubReleaseTags(ctx, client, owner, repo, cfg.IsSkipPrereleases())
+	} else {
+		tags, err = listGitHubTags(ctx, client, owner, repo)
+	}
+	if isGitHubNotFound(err) {
+		log.Printf("No %s found in repository '%s'", valueOr(cfg.BaseTagSource, "tags"), cfg.Repo)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error listing %s in repository '%s': %v", valueOr(cfg.BaseTagSource, "tags"), cfg.Repo, err)
+	}
+
+	// Return the first tag by version that is reachable from the current SHA
+	for _, tag := range SortTagsByVersion(tags, pattern, cfg.IsSkipPrereleases()) {
+		sha, _, err := client.Repositories.GetCommitSHA1(ctx, owner, repo, "refs/tags/"+tag, "")
+		// A deleted tag, or a tag of a tree or blob
+		if isGitHubNotFound(err) || isGitHubStatus(err, http.StatusUnprocessableEntity) {
+			log.Printf("Skipping tag %s, could not find its commit: %v", tag, err)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("error retrieving SHA for tag '%s' in repository '%s': %v", tag, cfg.Repo, err)
+		}
+
+		isAncestor, err := IsGitHubAncestor(client, cfg, sha)
+		if err != nil {
+			return "", fmt.Errorf("error checking tag '%s' is an ancestor of %s: %v", tag, cfg.Sha, err)
+		}
+		if isAncestor {
+			log.Printf("Latest tag matching %s: %s, SHA %s", pattern, tag, sha)
+			return sha, nil
+		}
+	}
+
+	log.Printf("No tags matching %s found for SHA %s", pattern, cfg.Sha)
+	return "", nil
+}
+
+// listGitHubTags lists the names of all tags in the repository.
+func listGitHubTags(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	var names []string
+	for {
+		tags, resp, err := client.Repositories.ListTags(ctx, owner, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			names = append(names, tag.GetName())
+		}
+
+		// loop to next page
+		if resp.NextPage == 0 {
+			return names, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// listGitHubReleaseTags lists the tag names of the published releases in the repository,
+// leaving out prereleases when skipPrereleases is set.
+func listGitHubReleaseTags(ctx context.Context, client *github.Client, owner, repo string, skipPrereleases bool) ([]string, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	var names []string
+	for {
+		releases, resp, err := client.Repositories.ListReleases(ctx, owner, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, release := range releases {
+			// Draft releases are not tagged yet
+			if release.GetDraft() || (skipPrereleases && release.GetPrerelease()) {
+				continue
+			}
+			names = append(names, release.GetTagName())
+		}
+
+		// loop to next page
+		if resp.NextPage == 0 {
+			return names, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// isGitHubNotFound reports whether the error is a 404 response of the GitHub API, e.g. for a missing commit.
+func isGitHubNotFound(err error) bool {
+	return isGitHubStatus(err, http.StatusNotFound)
+}
+
+// isGitHubStatus reports whether the error is a response of the GitHub API with the status code.
+func isGitHubStatus(err error, status int) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == status
 }
 
 // extractOwnerRepo takes a repository string in the format "owner/repo"
@@ -107,31 +576,220 @@ func GetClient(c *InputConfig) *github.Client {
 }
 
 // CompareGithubSHAs compares the commits between the base SHA and the current SHA for the
-// specified repository, and returns a list of the filenames that have changed.
+// specified repository, and returns the changes of the files.
 //
 // client is the GitHub API client to use for the comparison.
 // cfg is the input configuration containing the repository information and the current SHA.
 // baseSHA is the base SHA to compare against.
 //
-// Returns a slice of filenames that have changed between the base SHA and the current SHA.
+// Returns the changes of the files between the base SHA and the current SHA, including the files changed inside
+// the bumped submodules when recurse_submodules is set.
+// If the base SHA is EmptyTreeSHA, every file of the current SHA is returned as added.
 // If an error occurs during the comparison, an error is returned.
-func CompareGithubSHAs(client *github.Client, cfg *InputConfig, baseSHA string) ([]string, error) {
+func CompareGithubSHAs(client *github.Client, cfg *InputConfig, baseSHA string) ([]FileChange, error) {
 	// Create a background context for the GitHub API calls
 	ctx := context.Background()
 	// Extract the owner and repository names from the full repository path
 	owner, repo := extractOwnerRepo(cfg.Repo)
 
+	// The compare API only accepts commits, so list the tree of the current SHA instead
+	if baseSHA == EmptyTreeSHA {
+		return listGitHubTreeFiles(ctx, client, owner, repo, cfg.Sha)
+	}
+
 	// Compare the commits between the base SHA and the current SHA
 	comparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, baseSHA, cfg.Sha, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error comparing commits: %v", err)
 	}
 
-	// Extract the filenames from the comparison
-	var fileNames []string
+	// Describe the change of each file from the comparison
+	opts := cfg.CompareOptions()
+	var fileChanges []FileChange
 	for _, file := range comparison.Files {
-		fileNames = append(fileNames, file.GetFilename())
+		fileChanges = append(fileChanges, newGitHubFileChange(file))
+	}
+	if len(fileChanges) == 0 {
+		return nil, nil
 	}
 
-	return fileNames, nil
+	if err := setGitHubFileModes(ctx, client, owner, repo, baseSHA, cfg.Sha, fileChanges); err != nil {
+		return nil, err
+	}
+	// The headers of the unified diffs give the modes of added, deleted and chmod files
+	if opts.Patches {
+		for i, file := range comparison.Files {
+			fileChanges[i].Patch = formatGitHubPatch(fileChanges[i], file.GetPatch())
+		}
+	}
+	// Only read the blobs of the files kept by the filters
+	fileChanges = filterComparedChanges(fileChanges, opts)
+	setGitHubLFSPointers(ctx, client, cfg, fileChanges)
+	if opts.RecurseSubmodules {
+		fileChanges = compareGitHubSubmodules(ctx, client, cfg, fileChanges)
+	}
+	return fileChanges, nil
+}
+
+// setGitHubFileModes sets the modes and blob sizes of the changes, and the old blob hashes, from the trees of both
+// commits, as the compare API gives none of them. A modified file whose type changed, e.g. a file replaced by a symlink, is
+// reported as type changed. Files missing from a truncated tree keep empty modes.
+func setGitHubFileModes(ctx context.Context, client *github.Client, owner, repo, baseSHA, headSHA string, fileChanges []FileChange) error {
+	baseEntries, err := getGitHubTreeEntries(ctx, client, owner, repo, baseSHA)
+	if err != nil {
+		return err
+	}
+	headEntries, err := getGitHubTreeEntries(ctx, client, owner, repo, headSHA)
+	if err != nil {
+		return err
+	}
+
+	for i := range fileChanges {
+		change := &fileChanges[i]
+		previousPath := change.Path
+		if change.PreviousPath != "" {
+			previousPath = change.PreviousPath
+		}
+		if entry, found := baseEntries[previousPath]; found && change.Status != FileAdded {
+			change.OldMode, change.OldHash, change.OldSize = entry.GetMode(), entry.GetSHA(), int64(entry.GetSize())
+		}
+		if entry, found := headEntries[change.Path]; found && change.Status != FileDeleted {
+			change.NewMode, change.NewSize = entry.GetMode(), int64(entry.GetSize())
+		}
+
+		if change.Status == FileModified && change.OldMode != "" && change.NewMode != "" {
+			oldMode, oldErr := filemode.New(change.OldMode)
+			newMode, newErr := filemode.New(change.NewMode)
+			if oldErr == nil && newErr == nil && !isSameFileType(oldMode, newMode) {
+				change.Status = FileTypeChanged
+			}
+		}
+	}
+	return nil
+}
+
+// newGitHubFileChange describes the change of a file from the compare API, with its line counts. The API only gives
+// the new blob hash and no modes, set from the trees afterwards, as is the unified diff.
+func newGitHubFileChange(file *github.CommitFile) FileChange {
+	fileChange := FileChange{
+		Path:         file.GetFilename(),
+		PreviousPath: file.GetPreviousFilename(),
+		NewHash:      file.GetSHA(),
+		Additions:    file.GetAdditions(),
+		Deletions:    file.GetDeletions(),
+		Changes:      file.GetChanges(),
+	}
+
+	switch file.GetStatus() {
+	case "added":
+		fileChange.Status = FileAdded
+	case "removed":
+		fileChange.Status = FileDeleted
+		fileChange.OldHash, fileChange.NewHash = fileChange.NewHash, ""
+	case "renamed":
+		fileChange.Status = FileRenamed
+	case "copied":
+		fileChange.Status = FileCopied
+	default:
+		fileChange.Status = FileModified
+	}
+	return fileChange
+}
+
+// formatGitHubPatch prepends the headers of a unified diff to the hunks of a file from the compare API, with the
+// modes of the change once set from the trees. The API leaves out the hunks of binary files and large diffs, which
+// only get the extended headers.
+func formatGitHubPatch(fileChange FileChange, hunks string) string {
+	from, to := "a/"+fileChange.Path, "b/"+fileChange.Path
+	if fileChange.PreviousPath != "" {
+		from = "a/" + fileChange.PreviousPath
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git %s %s\n", from, to)
+	switch {
+	case fileChange.Status == FileAdded && fileChange.NewMode != "":
+		fmt.Fprintf(&sb, "new file mode %s\n", fileChange.NewMode)
+	case fileChange.Status == FileDeleted && fileChange.OldMode != "":
+		fmt.Fprintf(&sb, "deleted file mode %s\n", fileChange.OldMode)
+	case fileChange.OldMode != "" && fileChange.NewMode != "" && fileChange.OldMode != fileChange.NewMode:
+		fmt.Fprintf(&sb, "old mode %s\nnew mode %s\n", fileChange.OldMode, fileChange.NewMode)
+	}
+	if fileChange.PreviousPath != "" {
+		verb := "rename"
+		if fileChange.Status == FileCopied {
+			verb = "copy"
+		}
+		fmt.Fprintf(&sb, "%s from %s\n%s to %s\n", verb, fileChange.PreviousPath, verb, fileChange.Path)
+	}
+	if hunks == "" {
+		return sb.String()
+	}
+
+	switch fileChange.Status {
+	case FileAdded:
+		from = "/dev/null"
+	case FileDeleted:
+		to = "/dev/null"
+	}
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n%s", from, to, hunks)
+	if !strings.HasSuffix(hunks, "\n") {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// listGitHubTreeFiles lists all files in the tree of the commit as added. The tree gives no line counts, and the
+// blobs are not read for Git LFS pointers.
+func listGitHubTreeFiles(ctx context.Context, client *github.Client, owner, repo, sha string) ([]FileChange, error) {
+	entries, err := getGitHubTree(ctx, client, owner, repo, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileChanges []FileChange
+	for _, entry := range entries {
+		fileChanges = append(fileChanges, FileChange{
+			Path:    entry.GetPath(),
+			Status:  FileAdded,
+			NewHash: entry.GetSHA(),
+			NewMode: entry.GetMode(),
+			NewSize: int64(entry.GetSize()),
+		})
+	}
+	return fileChanges, nil
+}
+
+// getGitHubTreeEntries returns the entries of the files in the tree of the commit by their path.
+func getGitHubTreeEntries(ctx context.Context, client *github.Client, owner, repo, sha string) (map[string]*github.TreeEntry, error) {
+	entries, err := getGitHubTree(ctx, client, owner, repo, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	entriesByPath := make(map[string]*github.TreeEntry, len(entries))
+	for _, entry := range entries {
+		entriesByPath[entry.GetPath()] = entry
+	}
+	return entriesByPath, nil
+}
+
+// getGitHubTree returns the entries of the files in the tree of the commit, recursively, leaving out the folders.
+func getGitHubTree(ctx context.Context, client *github.Client, owner, repo, sha string) ([]*github.TreeEntry, error) {
+	tree, _, err := client.Git.GetTree(ctx, owner, repo, sha, true)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving tree of %s: %v", sha, err)
+	}
+	if tree.GetTruncated() {
+		log.Printf("Warning: the tree of %s is truncated by the GitHub API, use the offline mode to list every file", sha)
+	}
+
+	var entries []*github.TreeEntry
+	for _, entry := range tree.Entries {
+		// Skip the folders
+		if entry.GetType() == "tree" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
 }
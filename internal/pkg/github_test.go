@@ -6,7 +6,13 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/v66/github"
 )
@@ -74,7 +80,10 @@ func TestGetLatestSuccessfulDeploymentSha(t *testing.T) {
 	}
 
 	// Call the function under test
-	sha := GetLatestSuccessfulDeploymentSha(client, testConfig)
+	sha, err := GetLatestSuccessfulDeploymentSha(client, testConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 
 	// Assert the results
 	if sha == "" {
@@ -85,6 +94,331 @@ func TestGetLatestSuccessfulDeploymentSha(t *testing.T) {
 	}
 }
 
+func TestGetLatestSuccessfulDeploymentShaWithRules(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the ListDeployments endpoint, newest first
+	mux.HandleFunc("/repos/owner/repo/deployments", func(w http.ResponseWriter, r *http.Request) {
+		if task := r.URL.Query().Get("task"); task != "" && task != "deploy" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, `[
+			{"id": 1, "sha": "preview", "ref": "pr-1", "creator": {"login": "bot"}, "payload": {"kind": "preview"}},
+			{"id": 2, "sha": "rollback", "ref": "v1.0.0", "creator": {"login": "rollbacker"}, "payload": {"kind": "rollback"}},
+			{"id": 3, "sha": "inactive", "ref": "main", "creator": {"login": "deployer"}, "payload": {"kind": "release", "targets": [{"name": "eu"}]}},
+			{"id": 4, "sha": "success", "ref": "main", "creator": {"login": "deployer"}, "payload": {"kind": "release", "targets": [{"name": "us"}]}}
+		]`)
+	})
+
+	// Mock the ListDeploymentStatuses endpoints
+	states := map[int]string{1: "success", 2: "success", 3: "inactive", 4: "success"}
+	for id, state := range states {
+		mux.HandleFunc(fmt.Sprintf("/repos/owner/repo/deployments/%d/statuses", id), func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `[{"state": %q}]`, state)
+		})
+	}
+
+	testCases := []struct {
+		name        string
+		cfg         InputConfig
+		expectedSHA string
+	}{
+		{
+			name:        "No rules",
+			cfg:         InputConfig{},
+			expectedSHA: "preview",
+		},
+		{
+			name:        "Ref glob",
+			cfg:         InputConfig{DeploymentRef: "main"},
+			expectedSHA: "success",
+		},
+		{
+			name:        "Ref glob with accepted inactive state",
+			cfg:         InputConfig{DeploymentRef: "ma*", DeploymentStateList: []string{"success", "inactive"}},
+			expectedSHA: "inactive",
+		},
+		{
+			name:        "Creator",
+			cfg:         InputConfig{DeploymentCreator: "rollbacker"},
+			expectedSHA: "rollback",
+		},
+		{
+			name:        "Payload fields",
+			cfg:         InputConfig{DeploymentPayloadFields: map[string]string{"kind": "release", "targets.0.name": "us"}},
+			expectedSHA: "success",
+		},
+		{
+			name:        "Payload field missing",
+			cfg:         InputConfig{DeploymentPayloadFields: map[string]string{"region": "us"}},
+			expectedSHA: "",
+		},
+		{
+			name:        "Task",
+			cfg:         InputConfig{DeploymentTask: "deploy:migrations"},
+			expectedSHA: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Environment = "production"
+			tc.cfg.Repo = "owner/repo"
+			sha, err := GetLatestSuccessfulDeploymentSha(client, &tc.cfg)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sha != tc.expectedSHA {
+				t.Errorf("Expected deployment SHA %q, got %q", tc.expectedSHA, sha)
+			}
+		})
+	}
+}
+
+func TestGetLatestSuccessfulDeploymentShas(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the ListDeployments endpoint for all environments, newest first
+	var listed int
+	mux.HandleFunc("/repos/owner/repo/deployments", func(w http.ResponseWriter, r *http.Request) {
+		listed++
+		if env := r.URL.Query().Get("environment"); env != "" {
+			t.Errorf("Expected deployments of all environments, got environment %s", env)
+		}
+		fmt.Fprint(w, `[
+			{"id": 1, "sha": "dev2", "environment": "dev"},
+			{"id": 2, "sha": "staging1", "environment": "staging"},
+			{"id": 3, "sha": "other", "environment": "other"},
+			{"id": 4, "sha": "dev1", "environment": "dev"}
+		]`)
+	})
+
+	// Mock the ListDeploymentStatuses endpoints, the latest dev deployment failed
+	states := map[int]string{1: "failure", 2: "success", 3: "success", 4: "success"}
+	for id, state := range states {
+		mux.HandleFunc(fmt.Sprintf("/repos/owner/repo/deployments/%d/statuses", id), func(w http.ResponseWriter, r *http.Request) {
+			if id == 3 {
+				t.Errorf("Unexpected status lookup for deployment of another environment")
+			}
+			fmt.Fprintf(w, `[{"state": %q}]`, state)
+		})
+	}
+
+	cfg := &InputConfig{Repo: "owner/repo"}
+	shas, err := GetLatestSuccessfulDeploymentShas(client, cfg, []string{"dev", "staging", "prod"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]string{"dev": "dev1", "staging": "staging1"}
+	if !reflect.DeepEqual(shas, expected) {
+		t.Errorf("Expected deployment SHAs %v, got %v", expected, shas)
+	}
+	if listed != 1 {
+		t.Errorf("Expected deployments to be listed once, got %d", listed)
+	}
+}
+
+func TestGetLatestSuccessfulDeploymentShaConcurrently(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the ListDeployments endpoint with many failed deployments before two successful ones
+	mux.HandleFunc("/repos/owner/repo/deployments", func(w http.ResponseWriter, r *http.Request) {
+		var deployments []string
+		for id := 1; id <= 30; id++ {
+			deployments = append(deployments, fmt.Sprintf(`{"id": %d, "sha": "sha%d"}`, id, id))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(deployments, ","))
+	})
+
+	// Mock the ListDeploymentStatuses endpoint, the slow deployment 20 is the newest successful one
+	var inFlight, maxInFlight, lookups atomic.Int32
+	mux.HandleFunc("/repos/owner/repo/deployments/", func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		var id int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/deployments/"), "%d/statuses", &id)
+		switch {
+		case id == 20:
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(w, `[{"state": "success"}]`)
+		case id > 20:
+			fmt.Fprint(w, `[{"state": "success"}]`)
+		default:
+			fmt.Fprint(w, `[{"state": "failure"}]`)
+		}
+	})
+
+	cfg := &InputConfig{Environment: "production", Repo: "owner/repo", DeploymentConcurrency: 4}
+	sha, err := GetLatestSuccessfulDeploymentSha(client, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if sha != "sha20" {
+		t.Errorf("Expected deployment SHA sha20, got %s", sha)
+	}
+	if got := maxInFlight.Load(); got > 4 {
+		t.Errorf("Expected at most 4 concurrent status lookups, got %d", got)
+	}
+	if got := lookups.Load(); got >= 30 {
+		t.Errorf("Expected the lookups to stop after the successful deployment, got %d lookups", got)
+	}
+}
+
+func TestGetLatestSuccessfulDeploymentShaStatusError(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name      string
+		forbidden int64
+		expected  string
+		wantErr   bool
+	}{
+		{name: "Newer deployment status", forbidden: 1, wantErr: true},
+		{name: "Status after the successful deployment", forbidden: 2, expected: "sha1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			client, mux, _ := setup(t)
+
+			mux.HandleFunc("/repos/owner/repo/deployments", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `[{"id": 1, "sha": "sha1"}, {"id": 2, "sha": "sha2"}, {"id": 3, "sha": "sha3"}]`)
+			})
+			for id := int64(1); id <= 3; id++ {
+				mux.HandleFunc(fmt.Sprintf("/repos/owner/repo/deployments/%d/statuses", id), func(w http.ResponseWriter, r *http.Request) {
+					// A rate limited or unauthorized lookup
+					if id == tc.forbidden {
+						http.Error(w, `{"message": "API rate limit exceeded"}`, http.StatusForbidden)
+						return
+					}
+					fmt.Fprint(w, `[{"state": "success"}]`)
+				})
+			}
+
+			cfg := &InputConfig{Environment: "production", Repo: "owner/repo", DeploymentConcurrency: 1}
+			sha, err := GetLatestSuccessfulDeploymentSha(client, cfg)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got SHA %q", sha)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sha != tc.expected {
+				t.Errorf("Expected deployment SHA %s, got %s", tc.expected, sha)
+			}
+		})
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	t.Parallel()
+	data := map[string]any{
+		"kind":    "release",
+		"deploy":  map[string]any{"target": "eu", "replicas": float64(3)},
+		"targets": []any{map[string]any{"name": "us"}},
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected any
+		ok       bool
+	}{
+		{name: "Top level", path: "kind", expected: "release", ok: true},
+		{name: "Nested", path: "deploy.target", expected: "eu", ok: true},
+		{name: "Number", path: "deploy.replicas", expected: float64(3), ok: true},
+		{name: "Array index", path: "targets.0.name", expected: "us", ok: true},
+		{name: "Empty path", path: "", expected: data, ok: true},
+		{name: "Missing key", path: "deploy.region", ok: false},
+		{name: "Index out of range", path: "targets.1.name", ok: false},
+		{name: "Key into a string", path: "kind.name", ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, ok := lookupJSONPath(data, tc.path)
+			if ok != tc.ok {
+				t.Fatalf("lookupJSONPath(%q) ok = %v, want %v", tc.path, ok, tc.ok)
+			}
+			if ok && !reflect.DeepEqual(value, tc.expected) {
+				t.Errorf("lookupJSONPath(%q) = %v, want %v", tc.path, value, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGetLatestSuccessfulWorkflowRunSha(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the ListWorkflowRunsByFileName endpoint with two pages
+	mux.HandleFunc("/repos/owner/repo/actions/workflows/deploy.yml/runs", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("branch"); got != "main" {
+			t.Errorf("Expected branch main, got %s", got)
+		}
+		if got := r.URL.Query().Get("status"); got != "success" {
+			t.Errorf("Expected status success, got %s", got)
+		}
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"total_count": 2, "workflow_runs": [{"id": 3, "name": "deploy", "head_sha": "abc123"}]}`)
+			return
+		}
+		w.Header().Set("Link", `<`+r.URL.Path+`?page=2>; rel="next"`)
+		fmt.Fprint(w, `{"total_count": 2, "workflow_runs": [{"id": 1, "name": "deploy", "head_sha": "current"}]}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/actions/workflows/release.yml/runs", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"total_count": 0, "workflow_runs": []}`)
+	})
+
+	// Create a test InputConfig for the current run
+	testConfig := &InputConfig{
+		GithubToken: "test-token",
+		Repo:        "owner/repo",
+		WorkflowRef: "owner/repo/.github/workflows/deploy.yml@refs/heads/main",
+		RunID:       "1",
+	}
+
+	// Call the function under test
+	sha, err := GetLatestSuccessfulWorkflowRunSha(client, testConfig, "main")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Assert the results skip the current run
+	if sha != "abc123" {
+		t.Errorf("Expected workflow run SHA abc123, got %s", sha)
+	}
+
+	// No run of the workflow resolves to an empty SHA
+	testConfig.WorkflowRef = "owner/repo/.github/workflows/release.yml@refs/heads/main"
+	if sha, err := GetLatestSuccessfulWorkflowRunSha(client, testConfig, "main"); err != nil || sha != "" {
+		t.Errorf("Expected empty SHA, got %s and error %v", sha, err)
+	}
+
+	// A listing error is returned
+	testConfig.WorkflowRef = "owner/repo/.github/workflows/missing.yml@refs/heads/main"
+	if _, err := GetLatestSuccessfulWorkflowRunSha(client, testConfig, "main"); err == nil {
+		t.Errorf("Expected an error, but got nil")
+	}
+}
+
 func TestGetGitHubBranchLatestSHA(t *testing.T) {
 	t.Parallel()
 	client, mux, _ := setup(t)
@@ -111,7 +445,10 @@ func TestGetGitHubBranchLatestSHA(t *testing.T) {
 	}
 
 	// Call the function under test
-	sha := GetGitHubBranchLatestSHA(client, testConfig)
+	sha, err := GetGitHubBranchLatestSHA(client, testConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 
 	// Assert the results
 	if sha == "" {
@@ -120,7 +457,314 @@ func TestGetGitHubBranchLatestSHA(t *testing.T) {
 	if sha != "abc123" {
 		t.Errorf("Expected deployment SHA abc123, got %s", sha)
 	}
+
+	// A missing branch resolves to an empty SHA
+	testConfig.Branch = "missing"
+	if sha, err := GetGitHubBranchLatestSHA(client, testConfig); err != nil || sha != "" {
+		t.Errorf("Expected empty SHA for missing branch, got %s and error %v", sha, err)
+	}
+
+	// Other errors are returned, e.g. an expired token
+	mux.HandleFunc("/repos/owner/repo/git/ref/heads/expired", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+	})
+	testConfig.Branch = "expired"
+	if _, err := GetGitHubBranchLatestSHA(client, testConfig); err == nil {
+		t.Errorf("Expected an error, but got nil")
+	}
+}
+
+func TestGetGitHubCommitSHA(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the GetCommit endpoint with the SHA media type
+	mux.HandleFunc("/repos/owner/repo/commits/abc123", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "abc1234567890abcdef1234567890abcdef12345")
+	})
+
+	// Create a test InputConfig
+	testConfig := &InputConfig{
+		Commit:      "abc123",
+		GithubToken: "test-token",
+		Repo:        "owner/repo",
+	}
+
+	// Call the function under test
+	sha, err := GetGitHubCommitSHA(client, testConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Assert the results
+	if sha != "abc1234567890abcdef1234567890abcdef12345" {
+		t.Errorf("Expected commit SHA abc1234567890abcdef1234567890abcdef12345, got %s", sha)
+	}
+
+	// An unknown commit resolves to an empty SHA
+	testConfig.Commit = "unknown"
+	if sha, err := GetGitHubCommitSHA(client, testConfig); err != nil || sha != "" {
+		t.Errorf("Expected empty SHA for unknown commit, got %s and error %v", sha, err)
+	}
+
+	// Other errors are returned, e.g. a rate limit
+	mux.HandleFunc("/repos/owner/repo/commits/limited", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Server Error"}`, http.StatusInternalServerError)
+	})
+	testConfig.Commit = "limited"
+	if _, err := GetGitHubCommitSHA(client, testConfig); err == nil {
+		t.Errorf("Expected an error, but got nil")
+	}
+}
+
+func TestGetGitHubRevisionSHA(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// History: c1 <- c2 <- c3, and m4 merging c3 and f5
+	commits := map[string][]string{
+		"c1": {},
+		"c2": {"c1"},
+		"c3": {"c2"},
+		"m4": {"c3", "f5"},
+		"f5": {"c1"},
+	}
+	for sha, parents := range commits {
+		mux.HandleFunc("/repos/owner/repo/git/commits/"+sha, func(w http.ResponseWriter, r *http.Request) {
+			var parentsJSON []string
+			for _, parent := range parents {
+				parentsJSON = append(parentsJSON, fmt.Sprintf(`{"sha": "%s"}`, parent))
+			}
+			fmt.Fprintf(w, `{"sha": "%s", "parents": [%s]}`, sha, strings.Join(parentsJSON, ","))
+		})
+	}
+	// Mock the GetCommit endpoint resolving the starting points with the SHA media type
+	for name, sha := range map[string]string{"main": "m4", "feature": "f5", "v1.2.0": "c2", "m4": "m4", "c3": "c3"} {
+		mux.HandleFunc("/repos/owner/repo/commits/"+name, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, sha)
+		})
+	}
+
+	cfg := &InputConfig{Repo: "owner/repo", Sha: "c3", HeadRef: "feature"}
+
+	testCases := []struct {
+		rev      string
+		expected string
+		wantErr  bool
+	}{
+		{rev: "main", expected: "m4"},
+		{rev: "HEAD", expected: "c3"},
+		{rev: "HEAD~2", expected: "c1"},
+		{rev: "main^2", expected: "f5"},
+		{rev: "main^2~1", expected: "c1"},
+		{rev: "main^0", expected: "m4"},
+		{rev: "main~", expected: "c3"},
+		{rev: "v1.2.0^{commit}", expected: "c2"},
+		{rev: "main@{upstream}~1", expected: "c3"},
+		{rev: "@{u}", expected: "f5"},
+		{rev: "main^3", wantErr: true},
+		{rev: "HEAD~3", wantErr: true},
+		{rev: "main^{/fix}", wantErr: true},
+		{rev: "unknown", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.rev, func(t *testing.T) {
+			sha, err := GetGitHubRevisionSHA(client, cfg, tc.rev)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sha != tc.expected {
+				t.Errorf("GetGitHubRevisionSHA(%s) = %s, want %s", tc.rev, sha, tc.expected)
+			}
+		})
+	}
 }
+
+func TestGetGitHubParentSHA(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	mux.HandleFunc("/repos/owner/repo/git/commits/head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sha": "head456", "parents": [{"sha": "parent123"}, {"sha": "other789"}]}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/git/commits/root000", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sha": "root000", "parents": []}`)
+	})
+
+	cfg := &InputConfig{Repo: "owner/repo", Sha: "head456"}
+	parent, err := GetGitHubParentSHA(client, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parent != "parent123" {
+		t.Errorf("Expected parent SHA parent123, got %s", parent)
+	}
+
+	// A root commit has no parent
+	cfg.Sha = "root000"
+	parent, err = GetGitHubParentSHA(client, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parent != "" {
+		t.Errorf("Expected empty parent SHA for a root commit, got %s", parent)
+	}
+}
+
+func TestGetGitHubMergeBaseSHA(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the CompareCommits endpoint
+	mux.HandleFunc("/repos/owner/repo/compare/main123...head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"merge_base_commit": {"sha": "base789"}, "files": []}`)
+	})
+
+	cfg := &InputConfig{
+		Repo: "owner/repo",
+		Sha:  "head456",
+	}
+
+	mergeBase, err := GetGitHubMergeBaseSHA(client, cfg, "main123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mergeBase != "base789" {
+		t.Errorf("Expected merge base SHA base789, got %s", mergeBase)
+	}
+}
+
+func TestGetGitHubLatestTagSHA(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the ListTags and ListReleases endpoints
+	mux.HandleFunc("/repos/owner/repo/tags", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"name": "v2.0.0"}, {"name": "v1.1.0"}, {"name": "v1.0.0"}, {"name": "latest"}]`)
+	})
+	mux.HandleFunc("/repos/owner/repo/releases", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"tag_name": "v3.0.0", "draft": true},
+			{"tag_name": "v2.1.0-rc.1", "prerelease": true},
+			{"tag_name": "v1.1.0"}
+		]`)
+	})
+
+	// Mock the GetCommit endpoint resolving each tag
+	tagSHAs := map[string]string{"v2.1.0-rc.1": "sha210rc1", "v2.0.0": "sha200", "v1.1.0": "sha110", "v1.0.0": "sha100"}
+	for tag, sha := range tagSHAs {
+		mux.HandleFunc("/repos/owner/repo/commits/refs/tags/"+tag, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, sha)
+		})
+	}
+
+	// v2.0.0 is on another branch, the other tags are ancestors of the current SHA
+	mux.HandleFunc("/repos/owner/repo/compare/", func(w http.ResponseWriter, r *http.Request) {
+		status := "ahead"
+		if strings.Contains(r.URL.Path, "sha200...") {
+			status = "diverged"
+		}
+		fmt.Fprintf(w, `{"status": %q}`, status)
+	})
+
+	testCases := []struct {
+		name        string
+		cfg         InputConfig
+		expectedSHA string
+	}{
+		{
+			name:        "Tags",
+			cfg:         InputConfig{Repo: "owner/repo", Sha: "head"},
+			expectedSHA: "sha110",
+		},
+		{
+			name:        "Releases including prereleases",
+			cfg:         InputConfig{Repo: "owner/repo", Sha: "head", BaseTagSource: "releases"},
+			expectedSHA: "sha210rc1",
+		},
+		{
+			name:        "Releases skipping prereleases",
+			cfg:         InputConfig{Repo: "owner/repo", Sha: "head", BaseTagSource: "releases", SkipPrereleases: "true"},
+			expectedSHA: "sha110",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sha, err := GetGitHubLatestTagSHA(client, &tc.cfg, "v*")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sha != tc.expectedSHA {
+				t.Errorf("Expected tag SHA %s, got %s", tc.expectedSHA, sha)
+			}
+		})
+	}
+}
+
+func TestGetGitHubLatestTagSHAErrors(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name        string
+		tagsStatus  int
+		tagStatus   int
+		expectedSHA string
+		wantErr     bool
+	}{
+		{name: "Unauthorized tags listing", tagsStatus: http.StatusUnauthorized, wantErr: true},
+		{name: "Missing repository", tagsStatus: http.StatusNotFound},
+		{name: "Tag lookup failure", tagStatus: http.StatusInternalServerError, wantErr: true},
+		{name: "Deleted tag", tagStatus: http.StatusNotFound, expectedSHA: "sha100"},
+		{name: "Tag of a tree", tagStatus: http.StatusUnprocessableEntity, expectedSHA: "sha100"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			client, mux, _ := setup(t)
+
+			mux.HandleFunc("/repos/owner/repo/tags", func(w http.ResponseWriter, r *http.Request) {
+				if tc.tagsStatus != 0 {
+					http.Error(w, `{"message": "error"}`, tc.tagsStatus)
+					return
+				}
+				fmt.Fprint(w, `[{"name": "v1.1.0"}, {"name": "v1.0.0"}]`)
+			})
+			// The lookup of v1.1.0 fails
+			mux.HandleFunc("/repos/owner/repo/commits/refs/tags/v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"message": "error"}`, tc.tagStatus)
+			})
+			mux.HandleFunc("/repos/owner/repo/commits/refs/tags/v1.0.0", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "sha100")
+			})
+			mux.HandleFunc("/repos/owner/repo/compare/sha100...head", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"status": "ahead"}`)
+			})
+
+			sha, err := GetGitHubLatestTagSHA(client, &InputConfig{Repo: "owner/repo", Sha: "head"}, "v*")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got SHA %q", sha)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sha != tc.expectedSHA {
+				t.Errorf("Expected tag SHA %q, got %q", tc.expectedSHA, sha)
+			}
+		})
+	}
+}
+
 func TestCompareGithubSHAs(t *testing.T) {
 	t.Parallel()
 	client, mux, _ := setup(t)
@@ -129,23 +773,89 @@ func TestCompareGithubSHAs(t *testing.T) {
 		name          string
 		baseSHA       string
 		currentSHA    string
-		expectedFiles []string
+		expectedFiles []FileChange
 		expectedError bool
 		mockResponse  string
+		baseTree      string
+		headTree      string
 	}{
 		{
-			name:          "Successful comparison",
-			baseSHA:       "c6023e778dac2c67e7ec0c42889e349a76414292",
-			currentSHA:    "839bc7c55038951cfd3fed884617fd80d02ddbd4",
-			expectedFiles: []string{"file1.txt", "file2.go"},
+			name:       "Successful comparison",
+			baseSHA:    "c6023e778dac2c67e7ec0c42889e349a76414292",
+			currentSHA: "839bc7c55038951cfd3fed884617fd80d02ddbd4",
+			expectedFiles: []FileChange{
+				{Path: "file1.txt", Status: FileModified, OldHash: "sha0", NewHash: "sha1", OldMode: "100644", NewMode: "100644", Additions: 3, Deletions: 1, Changes: 4},
+				{Path: "file2.go", Status: FileAdded, NewHash: "sha2", NewMode: "100644", Additions: 10, Changes: 10},
+			},
+			expectedError: false,
+			mockResponse: `{"files": [
+				{"filename": "file1.txt", "status": "modified", "sha": "sha1", "additions": 3, "deletions": 1, "changes": 4},
+				{"filename": "file2.go", "status": "added", "sha": "sha2", "additions": 10, "deletions": 0, "changes": 10}
+			]}`,
+			baseTree: `[{"path": "file1.txt", "type": "blob", "mode": "100644", "sha": "sha0"}]`,
+			headTree: `[
+				{"path": "file1.txt", "type": "blob", "mode": "100644", "sha": "sha1"},
+				{"path": "file2.go", "type": "blob", "mode": "100644", "sha": "sha2"}
+			]`,
+		},
+		{
+			name:       "Deleted and renamed files",
+			baseSHA:    "c6023e778dac2c67e7ec0c42889e349a76414293",
+			currentSHA: "839bc7c55038951cfd3fed884617fd80d02ddbd3",
+			expectedFiles: []FileChange{
+				{Path: "old.txt", Status: FileDeleted, OldHash: "sha3", OldMode: "100644"},
+				{Path: "live/dev/main.tf", PreviousPath: "live/prod/main.tf", Status: FileRenamed, OldHash: "sha4", NewHash: "sha4", OldMode: "100644", NewMode: "100644"},
+				{Path: "copy.txt", PreviousPath: "file1.txt", Status: FileCopied, OldHash: "sha5", NewHash: "sha5", OldMode: "100755", NewMode: "100644"},
+			},
 			expectedError: false,
-			mockResponse:  `{"files": [{"filename": "file1.txt"}, {"filename": "file2.go"}]}`,
+			mockResponse: `{"files": [
+				{"filename": "old.txt", "status": "removed", "sha": "sha3"},
+				{"filename": "live/dev/main.tf", "previous_filename": "live/prod/main.tf", "status": "renamed", "sha": "sha4"},
+				{"filename": "copy.txt", "previous_filename": "file1.txt", "status": "copied", "sha": "sha5"}
+			]}`,
+			baseTree: `[
+				{"path": "file1.txt", "type": "blob", "mode": "100755", "sha": "sha5"},
+				{"path": "live", "type": "tree", "mode": "040000", "sha": "tree1"},
+				{"path": "live/prod/main.tf", "type": "blob", "mode": "100644", "sha": "sha4"},
+				{"path": "old.txt", "type": "blob", "mode": "100644", "sha": "sha3"}
+			]`,
+			headTree: `[
+				{"path": "copy.txt", "type": "blob", "mode": "100644", "sha": "sha5"},
+				{"path": "file1.txt", "type": "blob", "mode": "100755", "sha": "sha5"},
+				{"path": "live/dev/main.tf", "type": "blob", "mode": "100644", "sha": "sha4"}
+			]`,
+		},
+		{
+			name:       "Mode and type changes",
+			baseSHA:    "c6023e778dac2c67e7ec0c42889e349a76414295",
+			currentSHA: "839bc7c55038951cfd3fed884617fd80d02ddbd6",
+			expectedFiles: []FileChange{
+				{Path: "run.sh", Status: FileModified, OldHash: "sha6", NewHash: "sha6", OldMode: "100644", NewMode: "100755"},
+				{Path: "link", Status: FileTypeChanged, OldHash: "sha7", NewHash: "sha8", OldMode: "100644", NewMode: "120000", Additions: 1, Deletions: 1, Changes: 2},
+				{Path: "modules", Status: FileTypeChanged, OldHash: "sha9", NewHash: "sub1", OldMode: "100644", NewMode: "160000"},
+			},
+			expectedError: false,
+			mockResponse: `{"files": [
+				{"filename": "run.sh", "status": "changed", "sha": "sha6"},
+				{"filename": "link", "status": "modified", "sha": "sha8", "additions": 1, "deletions": 1, "changes": 2},
+				{"filename": "modules", "status": "modified", "sha": "sub1"}
+			]}`,
+			baseTree: `[
+				{"path": "run.sh", "type": "blob", "mode": "100644", "sha": "sha6"},
+				{"path": "link", "type": "blob", "mode": "100644", "sha": "sha7"},
+				{"path": "modules", "type": "blob", "mode": "100644", "sha": "sha9"}
+			]`,
+			headTree: `[
+				{"path": "run.sh", "type": "blob", "mode": "100755", "sha": "sha6"},
+				{"path": "link", "type": "blob", "mode": "120000", "sha": "sha8"},
+				{"path": "modules", "type": "commit", "mode": "160000", "sha": "sub1"}
+			]`,
 		},
 		{
 			name:          "Empty comparison",
 			baseSHA:       "c6023e778dac2c67e7ec0c42889e349a76414294",
 			currentSHA:    "839bc7c55038951cfd3fed884617fd80d02ddbd5",
-			expectedFiles: []string{},
+			expectedFiles: nil,
 			expectedError: false,
 			mockResponse:  `{"files": []}`,
 		},
@@ -167,6 +877,11 @@ func TestCompareGithubSHAs(t *testing.T) {
 				}
 				fmt.Fprint(w, tc.mockResponse)
 			})
+			for sha, tree := range map[string]string{tc.baseSHA: tc.baseTree, tc.currentSHA: tc.headTree} {
+				mux.HandleFunc("/repos/owner/repo/git/trees/"+sha, func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprintf(w, `{"sha": "%s", "truncated": false, "tree": %s}`, sha, tree)
+				})
+			}
 
 			cfg := &InputConfig{
 				Repo: "owner/repo",
@@ -184,19 +899,44 @@ func TestCompareGithubSHAs(t *testing.T) {
 					t.Errorf("Unexpected error: %v", err)
 				}
 
-				if len(files) != len(tc.expectedFiles) {
-					t.Errorf("Expected %d files, but got %d", len(tc.expectedFiles), len(files))
-				}
-				for i, file := range files {
-					if file != tc.expectedFiles[i] {
-						t.Errorf("Expected file %s, but got %s", tc.expectedFiles[i], file)
-					}
+				if !reflect.DeepEqual(files, tc.expectedFiles) {
+					t.Errorf("CompareGithubSHAs() = %v, want %v", files, tc.expectedFiles)
 				}
 			}
 		})
 	}
 }
 
+func TestCompareGithubSHAsEmptyTree(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the GetTree endpoint listing the whole tree of the head
+	mux.HandleFunc("/repos/owner/repo/git/trees/head456", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("recursive") != "1" {
+			t.Errorf("Expected a recursive tree request, got %s", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, `{"sha": "tree123", "truncated": false, "tree": [
+			{"path": "README.md", "type": "blob", "mode": "100644", "sha": "sha1"},
+			{"path": "src", "type": "tree", "mode": "040000", "sha": "sha2"},
+			{"path": "src/main.go", "type": "blob", "mode": "100755", "sha": "sha3"}
+		]}`)
+	})
+
+	cfg := &InputConfig{Repo: "owner/repo", Sha: "head456"}
+	files, err := CompareGithubSHAs(client, cfg, EmptyTreeSHA)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []FileChange{
+		{Path: "README.md", Status: FileAdded, NewHash: "sha1", NewMode: "100644"},
+		{Path: "src/main.go", Status: FileAdded, NewHash: "sha3", NewMode: "100755"},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("CompareGithubSHAs() = %v, want %v", files, expected)
+	}
+}
+
 func TestExtractOwnerRepo(t *testing.T) {
 	t.Parallel()
 	testCases := []struct {
@@ -243,3 +983,119 @@ func TestExtractOwnerRepo(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatGitHubPatch(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name       string
+		fileChange FileChange
+		hunks      string
+		expected   string
+	}{
+		{
+			name:       "Modified file",
+			fileChange: FileChange{Path: "a.txt", Status: FileModified},
+			hunks:      "@@ -1 +1 @@\n-a\n+b",
+			expected:   "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+b\n",
+		},
+		{
+			name:       "Added file",
+			fileChange: FileChange{Path: "a.txt", Status: FileAdded},
+			hunks:      "@@ -0,0 +1 @@\n+a",
+			expected:   "diff --git a/a.txt b/a.txt\n--- /dev/null\n+++ b/a.txt\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:       "Deleted file",
+			fileChange: FileChange{Path: "a.txt", Status: FileDeleted},
+			hunks:      "@@ -1 +0,0 @@\n-a",
+			expected:   "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name:       "Added file with its mode",
+			fileChange: FileChange{Path: "a.sh", Status: FileAdded, NewMode: "100755"},
+			hunks:      "@@ -0,0 +1 @@\n+a",
+			expected:   "diff --git a/a.sh b/a.sh\nnew file mode 100755\n--- /dev/null\n+++ b/a.sh\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:       "Deleted file with its mode",
+			fileChange: FileChange{Path: "a.txt", Status: FileDeleted, OldMode: "100644"},
+			hunks:      "@@ -1 +0,0 @@\n-a",
+			expected:   "diff --git a/a.txt b/a.txt\ndeleted file mode 100644\n--- a/a.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name:       "Renamed executable file",
+			fileChange: FileChange{Path: "b.sh", PreviousPath: "a.sh", Status: FileRenamed, OldMode: "100644", NewMode: "100755"},
+			expected:   "diff --git a/a.sh b/b.sh\nold mode 100644\nnew mode 100755\nrename from a.sh\nrename to b.sh\n",
+		},
+		{
+			name:       "Renamed file without hunks",
+			fileChange: FileChange{Path: "b.txt", PreviousPath: "a.txt", Status: FileRenamed},
+			expected:   "diff --git a/a.txt b/b.txt\nrename from a.txt\nrename to b.txt\n",
+		},
+		{
+			name:       "Copied binary file",
+			fileChange: FileChange{Path: "b.bin", PreviousPath: "a.bin", Status: FileCopied},
+			expected:   "diff --git a/a.bin b/b.bin\ncopy from a.bin\ncopy to b.bin\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if result := formatGitHubPatch(tc.fileChange, tc.hunks); result != tc.expected {
+				t.Errorf("formatGitHubPatch() = %q, want %q", result, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCompareGithubPatchesApply(t *testing.T) {
+	t.Parallel()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	repoPath, _ := newTestRepo(t, map[string]string{"old.txt": "old\n", "keep.txt": "a\n"})
+	client, mux, _ := setup(t)
+
+	mux.HandleFunc("/repos/owner/repo/compare/base123...head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"files": [
+			{"filename": "new.sh", "status": "added", "sha": "new1", "additions": 1, "changes": 1, "patch": "@@ -0,0 +1 @@\n+new"},
+			{"filename": "old.txt", "status": "removed", "sha": "old1", "deletions": 1, "changes": 1, "patch": "@@ -1 +0,0 @@\n-old"},
+			{"filename": "keep.txt", "status": "modified", "sha": "keep2", "additions": 1, "deletions": 1, "changes": 2, "patch": "@@ -1 +1 @@\n-a\n+b"}
+		]}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/git/trees/base123", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tree": [
+			{"path": "old.txt", "type": "blob", "mode": "100644", "sha": "old1"},
+			{"path": "keep.txt", "type": "blob", "mode": "100644", "sha": "keep1"}
+		]}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/git/trees/head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tree": [
+			{"path": "new.sh", "type": "blob", "mode": "100755", "sha": "new1"},
+			{"path": "keep.txt", "type": "blob", "mode": "100644", "sha": "keep2"}
+		]}`)
+	})
+
+	cfg := &InputConfig{Repo: "owner/repo", Sha: "head456", PatchFile: "delta.patch"}
+	changes, err := CompareGithubSHAs(client, cfg, "base123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var patch strings.Builder
+	for _, change := range changes {
+		patch.WriteString(change.Patch)
+	}
+	patchPath := filepath.Join(t.TempDir(), "delta.patch")
+	if err := os.WriteFile(patchPath, []byte(patch.String()), 0o600); err != nil {
+		t.Fatalf("Error writing patch: %v", err)
+	}
+
+	// The patch applies to the base
+	cmd := exec.Command(gitPath, "apply", "--check", patchPath)
+	cmd.Dir = repoPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("git apply --check failed: %v\n%s\n%s", err, output, patch.String())
+	}
+}
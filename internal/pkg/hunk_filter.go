@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// IgnoreWhitespace ignores the changes of whitespace within lines and of blank lines
+	IgnoreWhitespace = "whitespace"
+	// IgnoreLineEndings ignores the changes between LF and CRLF line endings
+	IgnoreLineEndings = "line-endings"
+	// IgnoreComments ignores the changes of lines that are line comments
+	IgnoreComments = "comments"
+)
+
+// ignoreChangeKinds lists the accepted ignore_changes kinds
+var ignoreChangeKinds = []string{IgnoreWhitespace, IgnoreLineEndings, IgnoreComments}
+
+// defaultCommentPrefixes are the prefixes of the line comments by file extension or name, overridden by
+// comment_syntax
+var defaultCommentPrefixes = map[string][]string{
+	".tf":        {"#", "//"},
+	".tfvars":    {"#", "//"},
+	".hcl":       {"#", "//"},
+	".yaml":      {"#"},
+	".yml":       {"#"},
+	".toml":      {"#"},
+	".py":        {"#"},
+	".sh":        {"#"},
+	".rb":        {"#"},
+	".go":        {"//"},
+	".js":        {"//"},
+	".ts":        {"//"},
+	".java":      {"//"},
+	".kt":        {"//"},
+	".c":         {"//"},
+	".h":         {"//"},
+	".cpp":       {"//"},
+	".cs":        {"//"},
+	".rs":        {"//"},
+	".sql":       {"--"},
+	".lua":       {"--"},
+	"Dockerfile": {"#"},
+	"Makefile":   {"#"},
+}
+
+// IgnoreOptions holds the kinds of changes that do not make a file count as changed
+type IgnoreOptions struct {
+	Whitespace  bool
+	LineEndings bool
+	Comments    bool
+	// Modes ignores the changes of mode within a file type, e.g. a file becoming executable
+	Modes bool
+	// CommentPrefixes are the prefixes of the line comments by file extension, e.g. ".tf", or file name, e.g.
+	// "Dockerfile"
+	CommentPrefixes map[string][]string
+}
+
+// IsEnabled reports whether any kind of change is ignored
+func (o IgnoreOptions) IsEnabled() bool {
+	return o.Whitespace || o.LineEndings || o.Comments || o.Modes
+}
+
+// FilterIgnoredChanges leaves out the modified files whose diff only changes what the options ignore, e.g. a
+// reformatting that only changes whitespace or a chmod. The diff is read from the patch of each change, so files
+// without hunks, such as binary files, are kept. Added, deleted, renamed, copied and type changed files are kept
+// too.
+func FilterIgnoredChanges(changes []FileChange, opts IgnoreOptions) []FileChange {
+	if !opts.IsEnabled() {
+		return changes
+	}
+
+	var result []FileChange
+	for _, change := range changes {
+		if change.Status == FileModified && isIgnoredChange(change, opts) {
+			log.Printf("Ignoring %s, its diff only changes %s", change.Path, opts.describe())
+			continue
+		}
+		result = append(result, change)
+	}
+	return result
+}
+
+// isIgnoredChange reports whether the mode of the modified file is unchanged or its change ignored, and its
+// content is unchanged or only changes what is ignored.
+func isIgnoredChange(change FileChange, opts IgnoreOptions) bool {
+	modeChanged := change.OldMode != change.NewMode
+	if modeChanged && !opts.Modes {
+		return false
+	}
+	if change.OldHash != "" && change.OldHash == change.NewHash {
+		return modeChanged
+	}
+	return isIgnoredPatch(change.Path, change.Patch, opts)
+}
+
+// isIgnoredPatch reports whether the patch of the file has hunks, and each run of lines they remove is the same as
+// the run of lines added at the same place once the ignored changes are normalized away. Comparing in place keeps a
+// line moved within or across hunks a change.
+func isIgnoredPatch(path, patch string, opts IgnoreOptions) bool {
+	blocks, hasHunks := changedBlocks(patch)
+	if !hasHunks {
+		return false
+	}
+	commentPrefixes := opts.commentPrefixes(path)
+	for _, block := range blocks {
+		if !slices.Equal(normalizeLines(block.removed, opts, commentPrefixes), normalizeLines(block.added, opts, commentPrefixes)) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeLines normalizes the lines for the ignored changes, leaving out the lines that become insignificant:
+// blank lines when whitespace is ignored and comment lines when comments are ignored.
+func normalizeLines(lines []string, opts IgnoreOptions, commentPrefixes []string) []string {
+	var result []string
+	for _, line := range lines {
+		if opts.LineEndings {
+			line = strings.TrimSuffix(line, "\r")
+		}
+		if opts.Comments && isCommentLine(line, commentPrefixes) {
+			continue
+		}
+		if opts.Whitespace {
+			line = strings.Join(strings.Fields(line), "")
+			if line == "" {
+				continue
+			}
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// isCommentLine reports whether the line starts with one of the comment prefixes, after its indentation.
+func isCommentLine(line string, commentPrefixes []string) bool {
+	line = strings.TrimSpace(line)
+	for _, prefix := range commentPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// commentPrefixes returns the prefixes of the line comments of the file, looked up by its extension, then by
+// its name.
+func (o IgnoreOptions) commentPrefixes(path string) []string {
+	if prefixes, found := o.CommentPrefixes[filepath.Ext(path)]; found {
+		return prefixes
+	}
+	return o.CommentPrefixes[filepath.Base(path)]
+}
+
+// describe lists the ignored kinds of changes for the logs.
+func (o IgnoreOptions) describe() string {
+	var kinds []string
+	if o.Whitespace {
+		kinds = append(kinds, IgnoreWhitespace)
+	}
+	if o.LineEndings {
+		kinds = append(kinds, IgnoreLineEndings)
+	}
+	if o.Comments {
+		kinds = append(kinds, IgnoreComments)
+	}
+	if o.Modes {
+		kinds = append(kinds, "modes")
+	}
+	return strings.Join(kinds, " or ")
+}
+
+// FilterContentChanges keeps the files with at least one removed or added line that matches one of the include
+// regular expressions, or any line without includes, and none of the exclude ones. Files without hunks, such as
+// binary files, cannot be matched: they are left out with includes and kept otherwise.
+func FilterContentChanges(changes []FileChange, includePatterns, excludePatterns []string) []FileChange {
+	if len(includePatterns) == 0 && len(excludePatterns) == 0 {
+		return changes
+	}
+	includes, excludes := mustCompileAll(includePatterns), mustCompileAll(excludePatterns)
+
+	var result []FileChange
+	for _, change := range changes {
+		removed, added, hasHunks := changedLines(change.Patch)
+		matched := slices.ContainsFunc(append(removed, added...), func(line string) bool {
+			return isMatchingLine(line, includes, excludes)
+		})
+		if matched || (!hasHunks && len(includes) == 0) {
+			result = append(result, change)
+			continue
+		}
+		log.Printf("Ignoring %s, none of its changed lines match the content patterns", change.Path)
+	}
+	return result
+}
+
+// isMatchingLine reports whether the line matches one of the includes, when any, and none of the excludes.
+func isMatchingLine(line string, includes, excludes []*regexp.Regexp) bool {
+	matchString := func(re *regexp.Regexp) bool { return re.MatchString(line) }
+	if len(includes) > 0 && !slices.ContainsFunc(includes, matchString) {
+		return false
+	}
+	return !slices.ContainsFunc(excludes, matchString)
+}
+
+// mustCompileAll compiles the regular expressions, validated with the inputs.
+func mustCompileAll(patterns []string) []*regexp.Regexp {
+	var regexps []*regexp.Regexp
+	for _, pattern := range patterns {
+		regexps = append(regexps, regexp.MustCompile(pattern))
+	}
+	return regexps
+}
+
+// changedLines returns the lines removed and added by the hunks of a unified diff of a file, without their
+// leading "-" or "+". The headers before the first hunk are skipped. Also reports whether the diff has hunks.
+func changedLines(patch string) (removed, added []string, hasHunks bool) {
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			hasHunks = true
+			continue
+		}
+		if !hasHunks {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "-"):
+			removed = append(removed, line[1:])
+		case strings.HasPrefix(line, "+"):
+			added = append(added, line[1:])
+		}
+	}
+	return removed, added, hasHunks
+}
+
+// changeBlock is a run of lines removed and added by a hunk between two context lines
+type changeBlock struct {
+	removed []string
+	added   []string
+}
+
+// changedBlocks returns the runs of lines removed and added by the hunks of a unified diff of a file, split on the
+// context lines and the hunk headers, without their leading "-" or "+". Also reports whether the diff has hunks.
+func changedBlocks(patch string) (blocks []changeBlock, hasHunks bool) {
+	var block changeBlock
+	flush := func() {
+		if len(block.removed) > 0 || len(block.added) > 0 {
+			blocks = append(blocks, block)
+		}
+		block = changeBlock{}
+	}
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			flush()
+			hasHunks = true
+			continue
+		}
+		if !hasHunks {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "-"):
+			block.removed = append(block.removed, line[1:])
+		case strings.HasPrefix(line, "+"):
+			block.added = append(block.added, line[1:])
+		case strings.HasPrefix(line, " "):
+			flush()
+		}
+	}
+	flush()
+	return blocks, hasHunks
+}
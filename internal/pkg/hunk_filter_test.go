@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedLines(t *testing.T) {
+	t.Parallel()
+	patch := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1,3 +1,3 @@\n a\n-b\n+c\n d\n\\ No newline at end of file\n@@ -10 +10,2 @@\n--x\n+-x\n+++y\n"
+
+	removed, added, hasHunks := changedLines(patch)
+	assert.True(t, hasHunks)
+	assert.Equal(t, []string{"b", "-x"}, removed)
+	assert.Equal(t, []string{"c", "-x", "++y"}, added)
+
+	_, _, hasHunks = changedLines("diff --git a/a.bin b/a.bin\nBinary files a/a.bin and b/a.bin differ\n")
+	assert.False(t, hasHunks)
+}
+
+func TestChangedBlocks(t *testing.T) {
+	t.Parallel()
+	patch := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1,4 +1,4 @@\n-a\n+b\n c\n-d\n-e\n+f\n\\ No newline at end of file\n@@ -10 +10 @@\n+g\n"
+
+	blocks, hasHunks := changedBlocks(patch)
+	assert.True(t, hasHunks)
+	assert.Equal(t, []changeBlock{
+		{removed: []string{"a"}, added: []string{"b"}},
+		{removed: []string{"d", "e"}, added: []string{"f"}},
+		{added: []string{"g"}},
+	}, blocks)
+}
+
+func TestFilterIgnoredChanges(t *testing.T) {
+	t.Parallel()
+	hunk := func(removed, added string) string {
+		return "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n keep\n-" + removed + "\n+" + added + "\n"
+	}
+	changes := []FileChange{
+		{Path: "indent.tf", Status: FileModified, Patch: hunk("  a = 1", "    a =  1")},
+		{Path: "crlf.txt", Status: FileModified, Patch: hunk("a\r", "a")},
+		{Path: "comment.tf", Status: FileModified, Patch: hunk("# old", "// new")},
+		{Path: "comment.yaml", Status: FileModified, Patch: hunk("  # old", "  # new")},
+		{Path: "comment.txt", Status: FileModified, Patch: hunk("# old", "# new")},
+		{Path: "blank.tf", Status: FileModified, Patch: "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -1 +1,2 @@\n keep\n+\n"},
+		{Path: "value.tf", Status: FileModified, Patch: hunk("a = 1", "a = 2")},
+		{Path: "added.tf", Status: FileAdded, Patch: "diff --git a/f b/f\n--- /dev/null\n+++ b/f\n@@ -0,0 +1 @@\n+# comment\n"},
+		{Path: "binary.bin", Status: FileModified, Patch: "diff --git a/f b/f\nBinary files a/f and b/f differ\n"},
+		{Path: "mode.sh", Status: FileModified, OldHash: "1", NewHash: "1", OldMode: "100644", NewMode: "100755", Patch: "diff --git a/f b/f\n"},
+		{Path: "mode.tf", Status: FileModified, OldHash: "1", NewHash: "2", OldMode: "100644", NewMode: "100755", Patch: hunk("a = 1", "a =  1")},
+		{Path: "link", Status: FileTypeChanged, OldHash: "1", NewHash: "2", OldMode: "100644", NewMode: "120000"},
+		{Path: "reordered.tf", Status: FileModified, Patch: "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n-count = 1\n other = 2\n+count = 1\n"},
+		{Path: "moved.tf", Status: FileModified, Patch: "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -1,2 +1 @@\n keep\n-a = 1\n@@ -10 +9,2 @@\n keep\n+a = 1\n"},
+	}
+	allChanged := []string{"indent.tf", "crlf.txt", "comment.tf", "comment.yaml", "comment.txt", "blank.tf", "value.tf", "added.tf", "binary.bin", "mode.sh", "mode.tf", "link", "reordered.tf", "moved.tf"}
+
+	testCases := []struct {
+		name     string
+		opts     IgnoreOptions
+		expected []string
+	}{
+		{
+			name:     "Nothing ignored",
+			opts:     IgnoreOptions{},
+			expected: allChanged,
+		},
+		{
+			name:     "Whitespace",
+			opts:     IgnoreOptions{Whitespace: true},
+			expected: []string{"comment.tf", "comment.yaml", "comment.txt", "value.tf", "added.tf", "binary.bin", "mode.sh", "mode.tf", "link", "reordered.tf", "moved.tf"},
+		},
+		{
+			name:     "Line endings",
+			opts:     IgnoreOptions{LineEndings: true},
+			expected: []string{"indent.tf", "comment.tf", "comment.yaml", "comment.txt", "blank.tf", "value.tf", "added.tf", "binary.bin", "mode.sh", "mode.tf", "link", "reordered.tf", "moved.tf"},
+		},
+		{
+			name:     "Comments with the default syntax",
+			opts:     IgnoreOptions{Comments: true, CommentPrefixes: defaultCommentPrefixes},
+			expected: []string{"indent.tf", "crlf.txt", "comment.txt", "blank.tf", "value.tf", "added.tf", "binary.bin", "mode.sh", "mode.tf", "link", "reordered.tf", "moved.tf"},
+		},
+		{
+			name:     "Comments with a custom syntax",
+			opts:     IgnoreOptions{Comments: true, CommentPrefixes: map[string][]string{".txt": {"#"}, ".tf": {"//"}}},
+			expected: []string{"indent.tf", "crlf.txt", "comment.tf", "comment.yaml", "blank.tf", "value.tf", "added.tf", "binary.bin", "mode.sh", "mode.tf", "link", "reordered.tf", "moved.tf"},
+		},
+		{
+			name:     "Modes",
+			opts:     IgnoreOptions{Modes: true},
+			expected: []string{"indent.tf", "crlf.txt", "comment.tf", "comment.yaml", "comment.txt", "blank.tf", "value.tf", "added.tf", "binary.bin", "mode.tf", "link", "reordered.tf", "moved.tf"},
+		},
+		{
+			name:     "Modes and whitespace",
+			opts:     IgnoreOptions{Modes: true, Whitespace: true},
+			expected: []string{"comment.tf", "comment.yaml", "comment.txt", "value.tf", "added.tf", "binary.bin", "link", "reordered.tf", "moved.tf"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, FileChangePaths(FilterIgnoredChanges(changes, tc.opts)))
+		})
+	}
+}
+
+func TestFilterIgnoredChangesOffline(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"main.tf": "resource \"a\" \"b\" {\n  name = \"b\"\n}\n", "vars.tf": "variable \"a\" {}\n"},
+	)
+	// Reformat main.tf with CRLF line endings and a comment, and change vars.tf
+	head := addTestCommit(t, repoPath, map[string]string{
+		"main.tf": "# The b resource\r\nresource \"a\" \"b\" {\r\n    name = \"b\"\r\n}\r\n",
+		"vars.tf": "variable \"b\" {}\n",
+	})
+
+	changes, err := CompareGitFolderSHAs(repoPath, shas[0], head, CompareOptions{Patches: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	opts := IgnoreOptions{Whitespace: true, LineEndings: true, Comments: true, CommentPrefixes: defaultCommentPrefixes}
+	assert.Equal(t, []string{"vars.tf"}, FileChangePaths(FilterIgnoredChanges(changes, opts)))
+}
+
+func TestFilterContentChanges(t *testing.T) {
+	t.Parallel()
+	patch := func(lines ...string) string {
+		return "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n image:\n" + strings.Join(lines, "\n") + "\n"
+	}
+	changes := []FileChange{
+		{Path: "tag.yaml", Status: FileModified, Patch: patch("-  tag: v1", "+  tag: v2")},
+		{Path: "replicas.yaml", Status: FileModified, Patch: patch("-replicas: 1", "+replicas: 2")},
+		{Path: "both.yaml", Status: FileModified, Patch: patch("-  tag: v1", "+  tag: v2", "-replicas: 1", "+replicas: 2")},
+		{Path: "removed.yaml", Status: FileDeleted, Patch: patch("-  tag: v1")},
+		{Path: "binary.bin", Status: FileModified, Patch: "diff --git a/f b/f\nBinary files a/f and b/f differ\n"},
+	}
+
+	testCases := []struct {
+		name     string
+		includes []string
+		excludes []string
+		expected []string
+	}{
+		{
+			name:     "No patterns",
+			expected: []string{"tag.yaml", "replicas.yaml", "both.yaml", "removed.yaml", "binary.bin"},
+		},
+		{
+			name:     "Includes on removed or added lines",
+			includes: []string{`^\s*tag:`},
+			expected: []string{"tag.yaml", "both.yaml", "removed.yaml"},
+		},
+		{
+			name:     "Excludes leave out the files with only excluded lines",
+			excludes: []string{`^replicas:`},
+			expected: []string{"tag.yaml", "both.yaml", "removed.yaml", "binary.bin"},
+		},
+		{
+			name:     "Includes and excludes",
+			includes: []string{`^\s*tag:`, `^replicas:`},
+			excludes: []string{`tag: v1`, `replicas: 1`},
+			expected: []string{"tag.yaml", "replicas.yaml", "both.yaml"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, FileChangePaths(FilterContentChanges(changes, tc.includes, tc.excludes)))
+		})
+	}
+}
+
+func TestFilterContentChangesOffline(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"dev/values.yaml": "image:\n  tag: v1\nreplicas: 1\n", "prod/values.yaml": "image:\n  tag: v1\nreplicas: 1\n"},
+	)
+	head := addTestCommit(t, repoPath, map[string]string{
+		"dev/values.yaml":  "image:\n  tag: v2\nreplicas: 1\n",
+		"prod/values.yaml": "image:\n  tag: v1\nreplicas: 3\n",
+	})
+
+	changes, err := CompareGitFolderSHAs(repoPath, shas[0], head, CompareOptions{Patches: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, []string{"dev/values.yaml"}, FileChangePaths(FilterContentChanges(changes, []string{`image\.tag:|^\s*tag:`}, nil)))
+}
@@ -2,6 +2,10 @@ package internal
 
 import (
 	"log"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/bmatcuk/doublestar/v4"
@@ -15,22 +19,65 @@ const (
 
 // InputConfig holds the configuration for the Action Inputs
 type InputConfig struct {
-	Environment      string `env:"INPUT_ENVIRONMENT"`
-	Commit           string `env:"INPUT_COMMIT"`
-	Includes         string `env:"INPUT_INCLUDES"`
-	Excludes         string `env:"INPUT_EXCLUDES"`
-	GithubToken      string `env:"INPUT_GITHUB_TOKEN"`
-	Sha              string `env:"GITHUB_SHA"`
-	Ref              string `env:"GITHUB_REF"`
-	ApiUrl           string `env:"GITHUB_API_URL"`
-	Workflow         string `env:"GITHUB_WORKFLOW"`
-	EventName        string `env:"GITHUB_EVENT_NAME"`
-	Job              string `env:"GITHUB_JOB"`
-	Repo             string `env:"GITHUB_REPOSITORY"`
-	Branch           string `env:"INPUT_BRANCH"`
-	online           string `env:"INPUT_ONLINE"`
-	IncludesPatterns []string
-	ExcludesPatterns []string
+	Environment             string `env:"INPUT_ENVIRONMENT"`
+	Commit                  string `env:"INPUT_COMMIT"`
+	Includes                string `env:"INPUT_INCLUDES"`
+	Excludes                string `env:"INPUT_EXCLUDES"`
+	GithubToken             string `env:"INPUT_GITHUB_TOKEN"`
+	Sha                     string `env:"GITHUB_SHA"`
+	Head                    string `env:"INPUT_HEAD"`
+	Ref                     string `env:"GITHUB_REF"`
+	HeadRef                 string `env:"GITHUB_HEAD_REF"`
+	ApiUrl                  string `env:"GITHUB_API_URL"`
+	WorkflowRef             string `env:"GITHUB_WORKFLOW_REF"`
+	RunID                   string `env:"GITHUB_RUN_ID"`
+	EventName               string `env:"GITHUB_EVENT_NAME"`
+	EventPath               string `env:"GITHUB_EVENT_PATH"`
+	Job                     string `env:"GITHUB_JOB"`
+	Repo                    string `env:"GITHUB_REPOSITORY"`
+	Branch                  string `env:"INPUT_BRANCH"`
+	Online                  string `env:"INPUT_ONLINE"`
+	BaseChain               string `env:"INPUT_BASE_CHAIN"`
+	MergeBase               string `env:"INPUT_MERGE_BASE"`
+	BaseTagPattern          string `env:"INPUT_BASE_TAG_PATTERN"`
+	BaseTagSource           string `env:"INPUT_BASE_TAG_SOURCE"`
+	SkipPrereleases         string `env:"INPUT_BASE_TAG_SKIP_PRERELEASES"`
+	DeploymentTask          string `env:"INPUT_DEPLOYMENT_TASK"`
+	DeploymentRef           string `env:"INPUT_DEPLOYMENT_REF"`
+	DeploymentCreator       string `env:"INPUT_DEPLOYMENT_CREATOR"`
+	DeploymentPayload       string `env:"INPUT_DEPLOYMENT_PAYLOAD"`
+	DeploymentStates        string `env:"INPUT_DEPLOYMENT_STATES"`
+	DeploymentConcurrency   int    `env:"INPUT_DEPLOYMENT_CONCURRENCY" envDefault:"5"`
+	DeepenMaxDepth          int    `env:"INPUT_DEEPEN_MAX_DEPTH" envDefault:"1024"`
+	EnvironmentSource       string `env:"INPUT_ENVIRONMENT_SOURCE"`
+	DeploymentRefPrefix     string `env:"INPUT_DEPLOYMENT_REF_PREFIX" envDefault:"refs/deployments/"`
+	Mode                    string `env:"INPUT_MODE"`
+	RecordPush              string `env:"INPUT_RECORD_PUSH"`
+	BaseFromFile            string `env:"INPUT_BASE_FROM_FILE"`
+	BaseFileSelector        string `env:"INPUT_BASE_FILE_SELECTOR"`
+	NoBase                  string `env:"INPUT_NO_BASE"`
+	DivergedBase            string `env:"INPUT_DIVERGED_BASE"`
+	RenameThreshold         int    `env:"INPUT_RENAME_THRESHOLD" envDefault:"50"`
+	DetectCopies            string `env:"INPUT_DETECT_COPIES"`
+	PatchFile               string `env:"INPUT_PATCH_FILE"`
+	IgnoreChanges           string `env:"INPUT_IGNORE_CHANGES"`
+	CommentSyntax           string `env:"INPUT_COMMENT_SYNTAX"`
+	IgnoreModeChanges       string `env:"INPUT_IGNORE_MODE_CHANGES"`
+	RecurseSubmodules       string `env:"INPUT_RECURSE_SUBMODULES"`
+	ContentIncludes         string `env:"INPUT_CONTENT_INCLUDES"`
+	ContentExcludes         string `env:"INPUT_CONTENT_EXCLUDES"`
+	MinSize                 string `env:"INPUT_MIN_SIZE"`
+	MaxSize                 string `env:"INPUT_MAX_SIZE"`
+	IncludesPatterns        []string
+	ExcludesPatterns        []string
+	BaseChainEntries        []string
+	EnvironmentList         []string
+	DeploymentPayloadFields map[string]string
+	DeploymentStateList     []string
+	IgnoreChangeList        []string
+	CommentSyntaxFields     map[string]string
+	ContentIncludePatterns  []string
+	ContentExcludePatterns  []string
 }
 
 // GetInputConfig parses environment variables into an InputConfig struct
@@ -51,20 +98,92 @@ func GetInputConfig() InputConfig {
 	if c.Excludes != "" {
 		c.ExcludesPatterns = strings.Split(c.Excludes, FileSeparator)
 	}
+
+	// If Environment is not empty, split it into EnvironmentList by newlines or commas
+	if c.Environment != "" {
+		c.EnvironmentList = splitList(c.Environment)
+		if len(c.EnvironmentList) == 1 {
+			c.Environment = c.EnvironmentList[0]
+		}
+	}
+
+	// If BaseChain is not empty, split it into BaseChainEntries
+	if c.BaseChain != "" {
+		c.BaseChainEntries = strings.Split(c.BaseChain, FileSeparator)
+	}
+
+	// If DeploymentPayload is not empty, split it into the key=value DeploymentPayloadFields
+	if c.DeploymentPayload != "" {
+		c.DeploymentPayloadFields = parseKeyValues(c.DeploymentPayload)
+	}
+
+	// If DeploymentStates is not empty, split it into DeploymentStateList
+	if c.DeploymentStates != "" {
+		for _, state := range strings.Split(c.DeploymentStates, FileSeparator) {
+			if state = strings.TrimSpace(state); state != "" {
+				c.DeploymentStateList = append(c.DeploymentStateList, state)
+			}
+		}
+	}
+
+	// If IgnoreChanges is not empty, split it into IgnoreChangeList by newlines or commas
+	if c.IgnoreChanges != "" {
+		c.IgnoreChangeList = splitList(c.IgnoreChanges)
+	}
+
+	// If CommentSyntax is not empty, split it into the extension=prefixes CommentSyntaxFields
+	if c.CommentSyntax != "" {
+		c.CommentSyntaxFields = parseKeyValues(c.CommentSyntax)
+	}
+
+	// If ContentIncludes is not empty, split it into ContentIncludePatterns
+	if c.ContentIncludes != "" {
+		c.ContentIncludePatterns = strings.Split(c.ContentIncludes, FileSeparator)
+	}
+
+	// If ContentExcludes is not empty, split it into ContentExcludePatterns
+	if c.ContentExcludes != "" {
+		c.ContentExcludePatterns = strings.Split(c.ContentExcludes, FileSeparator)
+	}
 	return c
 }
 
 // Validate checks if the required fields in InputConfig are set
 func (c *InputConfig) Validate() {
-	if c.Environment != "" && c.GithubToken == "" {
-		log.Panic("github_token must be specific when the environment is given")
+	if c.EnvironmentSource != "" && c.EnvironmentSource != "api" && c.EnvironmentSource != "ref" {
+		log.Panicf("environment_source must be api or ref, got '%s'", c.EnvironmentSource)
+	}
+
+	if c.NoBase != "" && !slices.Contains(noBasePolicies, c.NoBase) {
+		log.Panicf("no_base must be one of %s, got '%s'", strings.Join(noBasePolicies, ", "), c.NoBase)
+	}
+
+	if c.DivergedBase != "" && !slices.Contains(divergedStrategies, c.DivergedBase) {
+		log.Panicf("diverged_base must be one of %s, got '%s'", strings.Join(divergedStrategies, ", "), c.DivergedBase)
+	}
+
+	for _, kind := range c.IgnoreChangeList {
+		if !slices.Contains(ignoreChangeKinds, kind) {
+			log.Panicf("ignore_changes must be a list of %s, got '%s'", strings.Join(ignoreChangeKinds, ", "), kind)
+		}
+	}
+
+	if c.Mode != "" && c.Mode != "delta" && c.Mode != "record" {
+		log.Panicf("mode must be delta or record, got '%s'", c.Mode)
+	}
+	if c.IsRecord() && (c.Environment == "" || !c.IsEnvironmentFromRef()) && c.BaseFromFile == "" {
+		log.Panic("environment with environment_source ref, or base_from_file must be specific when the mode is record")
 	}
 
-	if c.online == "true" {
+	if len(c.EnvironmentList) > 1 && c.PatchFile != "" && !strings.Contains(c.PatchFile, EnvironmentPlaceholder) {
+		log.Panicf("patch_file must contain %s when several environments are given", EnvironmentPlaceholder)
+	}
+
+	if c.IsOnline() {
 		if c.GithubToken == "" {
 			log.Panic("github_token must be specific when online is set to true")
 		}
-	} else {
+	} else if c.DeepenMaxDepth == 0 {
 		log.Println("Warning: Offline mode might need the entire git history. Ensure the git clone depth is set to 0.")
 	}
 
@@ -77,6 +196,199 @@ func (c *InputConfig) Validate() {
 
 	validatePatterns(c.IncludesPatterns)
 	validatePatterns(c.ExcludesPatterns)
+	validatePatterns([]string{c.BaseTagPattern, c.DeploymentRef})
+	validateRegexps(c.ContentIncludePatterns)
+	validateRegexps(c.ContentExcludePatterns)
+
+	if c.DeploymentConcurrency < 0 {
+		log.Panicf("deployment_concurrency must not be negative, got %d", c.DeploymentConcurrency)
+	}
+	if c.DeepenMaxDepth < 0 {
+		log.Panicf("deepen_max_depth must not be negative, got %d", c.DeepenMaxDepth)
+	}
+	if c.RenameThreshold < 0 || c.RenameThreshold > 100 {
+		log.Panicf("rename_threshold must be between 0 and 100, got %d", c.RenameThreshold)
+	}
+	minSize, err := parseByteSize(c.MinSize)
+	if err != nil {
+		log.Panicf("min_size is invalid: %v", err)
+	}
+	maxSize, err := parseByteSize(c.MaxSize)
+	if err != nil {
+		log.Panicf("max_size is invalid: %v", err)
+	}
+	if maxSize > 0 && minSize > maxSize {
+		log.Panicf("min_size must not be greater than max_size, got %s and %s", c.MinSize, c.MaxSize)
+	}
+
+	if c.BaseTagSource != "" && c.BaseTagSource != "tags" && c.BaseTagSource != "releases" {
+		log.Panicf("base_tag_source must be tags or releases, got '%s'", c.BaseTagSource)
+	}
+
+	// The environment may only name the state file, so the token is only needed by the resolvers of the chain
+	entries := c.BaseChainEntries
+	if len(entries) == 0 {
+		entries = defaultBaseChain(c)
+	}
+	validateBaseChain(entries, c.GithubToken, c.IsEnvironmentFromRef())
+}
+
+// validateBaseChain checks that every base chain entry names a known resolver and that
+// a github token is given when the chain looks up environments.
+func validateBaseChain(entries []string, githubToken string, environmentFromRef bool) {
+	for _, entry := range entries {
+		kind, _ := splitResolverEntry(entry)
+		if kind == "" {
+			continue
+		}
+		if !slices.Contains(resolverKinds, kind) {
+			log.Panicf("Unknown resolver '%s' in base_chain, expected one of %s", kind, strings.Join(resolverKinds, ", "))
+		}
+		if ((kind == "environment" && !environmentFromRef) || kind == "workflow-run") && githubToken == "" {
+			log.Panicf("github_token must be specific when the base_chain looks up %s", kind)
+		}
+	}
+}
+
+// noBasePolicies lists the accepted no_base policies
+var noBasePolicies = []string{"all", "fail", "none", "parent"}
+
+// IsOnline reports whether the delta should be calculated against the GitHub API
+func (c *InputConfig) IsOnline() bool {
+	return c.Online == "true"
+}
+
+// CurrentBranch returns the branch the workflow runs on: the source branch of a pull request,
+// otherwise the branch of the ref. Returns an empty string when the ref is not a branch.
+func (c *InputConfig) CurrentBranch() string {
+	if c.HeadRef != "" {
+		return c.HeadRef
+	}
+	branch, found := strings.CutPrefix(c.Ref, "refs/heads/")
+	if !found {
+		return ""
+	}
+	return branch
+}
+
+// AcceptedDeploymentStates returns the deployment states considered successful, success by default
+func (c *InputConfig) AcceptedDeploymentStates() []string {
+	if len(c.DeploymentStateList) == 0 {
+		return []string{"success"}
+	}
+	return c.DeploymentStateList
+}
+
+// WorkflowFile returns the file name of the current workflow from its ref, e.g. deploy.yml for
+// owner/repo/.github/workflows/deploy.yml@refs/heads/main, or an empty string outside of a workflow.
+func (c *InputConfig) WorkflowFile() string {
+	workflowPath, _, _ := strings.Cut(c.WorkflowRef, "@")
+	if workflowPath == "" {
+		return ""
+	}
+	return path.Base(workflowPath)
+}
+
+// IsEnvironmentFromRef reports whether the latest deployment of an environment is read from a local git
+// reference such as refs/deployments/<environment> instead of the deployments API
+func (c *InputConfig) IsEnvironmentFromRef() bool {
+	return c.EnvironmentSource == "ref"
+}
+
+// DeploymentRefName returns the git reference tracking the latest deployment of the environment
+func (c *InputConfig) DeploymentRefName(environment string) string {
+	return c.DeploymentRefPrefix + environment
+}
+
+// IsRecord reports whether the current SHA should be recorded as the latest deployment instead of calculating a delta
+func (c *InputConfig) IsRecord() bool {
+	return c.Mode == "record"
+}
+
+// IsRecordPush reports whether the recorded deployment references should be pushed to the origin remote
+func (c *InputConfig) IsRecordPush() bool {
+	return c.RecordPush == "true"
+}
+
+// IsMergeBase reports whether the delta should be calculated from the merge base of the base SHA and the current SHA
+func (c *InputConfig) IsMergeBase() bool {
+	return c.MergeBase == "true"
+}
+
+// IsSkipPrereleases reports whether prerelease tags and releases should be skipped when resolving the base tag
+func (c *InputConfig) IsSkipPrereleases() bool {
+	return c.SkipPrereleases == "true"
+}
+
+// CompareOptions returns the options of the comparison between the base and the head
+func (c *InputConfig) CompareOptions() CompareOptions {
+	return CompareOptions{
+		RenameThreshold:   c.RenameThreshold,
+		DetectCopies:      c.DetectCopies == "true",
+		Patches:           c.PatchFile != "" || len(c.IgnoreChangeList) > 0 || c.HasContentPatterns(),
+		RecurseSubmodules: c.RecurseSubmodules == "true",
+		IncludePatterns:   c.IncludesPatterns,
+		ExcludePatterns:   c.ExcludesPatterns,
+	}
+}
+
+// SizeLimits returns the minimum and maximum sizes in bytes of the delta files, zero not limiting the size.
+// The sizes are validated by Validate.
+func (c *InputConfig) SizeLimits() (minSize, maxSize int64) {
+	minSize, _ = parseByteSize(c.MinSize)
+	maxSize, _ = parseByteSize(c.MaxSize)
+	return minSize, maxSize
+}
+
+// HasContentPatterns reports whether the changed lines of the files are filtered by content_includes or
+// content_excludes
+func (c *InputConfig) HasContentPatterns() bool {
+	return len(c.ContentIncludePatterns) > 0 || len(c.ContentExcludePatterns) > 0
+}
+
+// IgnoreOptions returns the kinds of changes that do not make a file count as changed, with the comment
+// prefixes of comment_syntax overriding the default ones by extension
+func (c *InputConfig) IgnoreOptions() IgnoreOptions {
+	opts := IgnoreOptions{
+		Whitespace:      slices.Contains(c.IgnoreChangeList, IgnoreWhitespace),
+		LineEndings:     slices.Contains(c.IgnoreChangeList, IgnoreLineEndings),
+		Comments:        slices.Contains(c.IgnoreChangeList, IgnoreComments),
+		Modes:           c.IgnoreModeChanges == "true",
+		CommentPrefixes: maps.Clone(defaultCommentPrefixes),
+	}
+	for extension, prefixes := range c.CommentSyntaxFields {
+		opts.CommentPrefixes[extension] = strings.Fields(prefixes)
+	}
+	return opts
+}
+
+// splitList splits the string by newlines and commas, trimming the items and leaving out empty ones
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseKeyValues parses the lines of key=value pairs separated by newlines into a map.
+// Lines without a "=" are logged and ignored.
+func parseKeyValues(s string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(s, FileSeparator) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			log.Printf("Ignoring '%s', expected key=value", line)
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
 }
 
 // validatePatterns checks that the provided patterns are valid regular expressions.
@@ -91,3 +403,13 @@ func validatePatterns(patterns []string) {
 		}
 	}
 }
+
+// validateRegexps checks that the provided patterns are valid regular expressions.
+// If any pattern is invalid, it logs a fatal error with the invalid pattern and error.
+func validateRegexps(patterns []string) {
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			log.Panicf("Error compiling regular expression '%s': %v", pattern, err)
+		}
+	}
+}
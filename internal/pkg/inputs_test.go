@@ -113,6 +113,102 @@ func TestGetInputConfigWithEmptyOptionalFields(t *testing.T) {
 	}
 }
 
+func TestGetInputConfigWithDeploymentRules(t *testing.T) {
+	os.Setenv("INPUT_DEPLOYMENT_TASK", "deploy")
+	os.Setenv("INPUT_DEPLOYMENT_REF", "main")
+	os.Setenv("INPUT_DEPLOYMENT_CREATOR", "deployer")
+	os.Setenv("INPUT_DEPLOYMENT_PAYLOAD", "kind=release\ndeploy.target=eu")
+	os.Setenv("INPUT_DEPLOYMENT_STATES", "success\ninactive\n")
+	defer func() {
+		for _, name := range []string{"INPUT_DEPLOYMENT_TASK", "INPUT_DEPLOYMENT_REF", "INPUT_DEPLOYMENT_CREATOR", "INPUT_DEPLOYMENT_PAYLOAD", "INPUT_DEPLOYMENT_STATES"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	ic := GetInputConfig()
+
+	assert.Equal(t, "deploy", ic.DeploymentTask)
+	assert.Equal(t, "main", ic.DeploymentRef)
+	assert.Equal(t, "deployer", ic.DeploymentCreator)
+	assert.Equal(t, map[string]string{"kind": "release", "deploy.target": "eu"}, ic.DeploymentPayloadFields)
+	assert.Equal(t, []string{"success", "inactive"}, ic.DeploymentStateList)
+}
+
+func TestSplitList(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []string{"dev", "staging", "prod"}, splitList("dev, staging,\nprod\n"))
+	assert.Equal(t, []string{"prod"}, splitList("prod"))
+	assert.Empty(t, splitList(" , \n"))
+}
+
+func TestParseKeyValues(t *testing.T) {
+	t.Parallel()
+	values := parseKeyValues("kind=release\n deploy.target = eu \n\ninvalid\nurl=https://example.com/?a=b")
+	assert.Equal(t, map[string]string{
+		"kind":          "release",
+		"deploy.target": "eu",
+		"url":           "https://example.com/?a=b",
+	}, values)
+}
+
+func TestAcceptedDeploymentStates(t *testing.T) {
+	t.Parallel()
+	cfg := InputConfig{}
+	assert.Equal(t, []string{"success"}, cfg.AcceptedDeploymentStates())
+
+	cfg.DeploymentStateList = []string{"success", "inactive"}
+	assert.Equal(t, []string{"success", "inactive"}, cfg.AcceptedDeploymentStates())
+}
+
+func TestIgnoreOptions(t *testing.T) {
+	t.Parallel()
+	cfg := InputConfig{
+		IgnoreChangeList:    []string{"whitespace", "comments"},
+		CommentSyntaxFields: map[string]string{".tf": "#", ".j2": "{# ##"},
+	}
+	opts := cfg.IgnoreOptions()
+
+	assert.True(t, opts.Whitespace)
+	assert.False(t, opts.LineEndings)
+	assert.True(t, opts.Comments)
+	assert.Equal(t, []string{"#"}, opts.CommentPrefixes[".tf"])
+	assert.Equal(t, []string{"{#", "##"}, opts.CommentPrefixes[".j2"])
+	assert.Equal(t, []string{"#"}, opts.CommentPrefixes[".yaml"])
+	// The defaults are left untouched
+	assert.Equal(t, []string{"#", "//"}, defaultCommentPrefixes[".tf"])
+}
+
+func TestCurrentBranch(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		cfg      InputConfig
+		expected string
+	}{
+		{
+			name:     "Push to a branch",
+			cfg:      InputConfig{Ref: "refs/heads/feature/new"},
+			expected: "feature/new",
+		},
+		{
+			name:     "Pull request",
+			cfg:      InputConfig{Ref: "refs/pull/1/merge", HeadRef: "feature/new"},
+			expected: "feature/new",
+		},
+		{
+			name:     "Tag",
+			cfg:      InputConfig{Ref: "refs/tags/v1.0.0"},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.cfg.CurrentBranch())
+		})
+	}
+}
+
 func TestValidatePatterns(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -220,10 +316,33 @@ func TestInputConfigValidate(t *testing.T) {
 			},
 			wantPanic: true,
 		},
+		{
+			name: "Valid config with environment naming the base file but no github token",
+			inputConfig: InputConfig{
+				Environment:      "prod",
+				BaseFromFile:     ".deploy/{environment}.json",
+				BaseFileSelector: "sha",
+				Mode:             "record",
+				Repo:             "test/repo",
+				Sha:              "def456",
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Invalid config with environment in the base chain but no github token",
+			inputConfig: InputConfig{
+				Environment:      "prod",
+				BaseFromFile:     ".deploy/state.json",
+				BaseChainEntries: []string{"file", "environment"},
+				Repo:             "test/repo",
+				Sha:              "def456",
+			},
+			wantPanic: true,
+		},
 		{
 			name: "Valid config with online mode and github token",
 			inputConfig: InputConfig{
-				online:      "true",
+				Online:      "true",
 				GithubToken: "ghp_validtoken",
 				Repo:        "test/repo",
 				Sha:         "ghi789",
@@ -233,7 +352,7 @@ func TestInputConfigValidate(t *testing.T) {
 		{
 			name: "Invalid config with online mode but no github token",
 			inputConfig: InputConfig{
-				online:      "true",
+				Online:      "true",
 				GithubToken: "",
 				Repo:        "test/repo",
 				Sha:         "jkl012",
@@ -243,7 +362,7 @@ func TestInputConfigValidate(t *testing.T) {
 		{
 			name: "Valid config with offline mode",
 			inputConfig: InputConfig{
-				online: "false",
+				Online: "false",
 				Repo:   "test/repo",
 				Sha:    "mno345",
 			},
@@ -265,6 +384,196 @@ func TestInputConfigValidate(t *testing.T) {
 			},
 			wantPanic: true,
 		},
+		{
+			name: "Valid config with environment from ref and no github token",
+			inputConfig: InputConfig{
+				Environment:       "staging",
+				EnvironmentSource: "ref",
+				Repo:              "test/repo",
+				Sha:               "efg123",
+				BaseChainEntries:  []string{"environment"},
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Invalid config with unknown environment source",
+			inputConfig: InputConfig{
+				EnvironmentSource: "notes",
+				Repo:              "test/repo",
+				Sha:               "hij456",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Valid config with record mode",
+			inputConfig: InputConfig{
+				Environment:       "prod",
+				EnvironmentSource: "ref",
+				Mode:              "record",
+				Repo:              "test/repo",
+				Sha:               "klm789",
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Valid config with record mode to base file",
+			inputConfig: InputConfig{
+				Mode:         "record",
+				BaseFromFile: ".deploy/prod.sha",
+				Repo:         "test/repo",
+				Sha:          "tuv678",
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Invalid config with record mode but no environment",
+			inputConfig: InputConfig{
+				Mode: "record",
+				Repo: "test/repo",
+				Sha:  "nop012",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Invalid config with unknown mode",
+			inputConfig: InputConfig{
+				Mode: "deploy",
+				Repo: "test/repo",
+				Sha:  "qrs345",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Invalid config with negative deepen max depth",
+			inputConfig: InputConfig{
+				DeepenMaxDepth: -1,
+				Repo:           "test/repo",
+				Sha:            "qrs348",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Invalid config with rename threshold above 100",
+			inputConfig: InputConfig{
+				RenameThreshold: 101,
+				Repo:            "test/repo",
+				Sha:             "qrs348",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Valid config with size limits",
+			inputConfig: InputConfig{
+				MinSize: "1MB",
+				MaxSize: "2 GB",
+				Repo:    "test/repo",
+				Sha:     "qrs348",
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Invalid config with an unknown size unit",
+			inputConfig: InputConfig{
+				MinSize: "1MiB",
+				Repo:    "test/repo",
+				Sha:     "qrs348",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Invalid config with min size above max size",
+			inputConfig: InputConfig{
+				MinSize: "2KB",
+				MaxSize: "1KB",
+				Repo:    "test/repo",
+				Sha:     "qrs348",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Invalid config with one patch file for several environments",
+			inputConfig: InputConfig{
+				EnvironmentList: []string{"dev", "prod"},
+				PatchFile:       "delta.patch",
+				Repo:            "test/repo",
+				Sha:             "qrs348",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Valid config with a patch file per environment",
+			inputConfig: InputConfig{
+				EnvironmentList: []string{"dev", "prod"},
+				PatchFile:       "patches/{environment}.patch",
+				Repo:            "test/repo",
+				Sha:             "qrs348",
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Invalid config with unknown ignored changes",
+			inputConfig: InputConfig{
+				IgnoreChangeList: []string{"whitespace", "formatting"},
+				Repo:             "test/repo",
+				Sha:              "qrs348",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Invalid config with invalid content regular expression",
+			inputConfig: InputConfig{
+				ContentIncludePatterns: []string{`image\.tag:`, `tag: (v1`},
+				Repo:                   "test/repo",
+				Sha:                    "qrs348",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Valid config with no base policy",
+			inputConfig: InputConfig{
+				NoBase: "parent",
+				Repo:   "test/repo",
+				Sha:    "qrs346",
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Invalid config with unknown no base policy",
+			inputConfig: InputConfig{
+				NoBase: "skip",
+				Repo:   "test/repo",
+				Sha:    "qrs347",
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Valid config with base chain",
+			inputConfig: InputConfig{
+				Repo:             "test/repo",
+				Sha:              "vwx234",
+				GithubToken:      "ghp_validtoken",
+				BaseChainEntries: []string{"environment", "merge-base:main", "commit:HEAD~1"},
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Invalid config with unknown resolver in base chain",
+			inputConfig: InputConfig{
+				Repo:             "test/repo",
+				Sha:              "yza567",
+				BaseChainEntries: []string{"branch", "unknown"},
+			},
+			wantPanic: true,
+		},
+		{
+			name: "Invalid config with environment in base chain but no github token",
+			inputConfig: InputConfig{
+				Repo:             "test/repo",
+				Sha:              "bcd890",
+				BaseChainEntries: []string{"environment:prod"},
+			},
+			wantPanic: true,
+		},
 		{
 			name: "Valid config with includes and excludes patterns",
 			inputConfig: InputConfig{
@@ -288,3 +597,10 @@ func TestInputConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestWorkflowFile(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "deploy.yml", (&InputConfig{WorkflowRef: "owner/repo/.github/workflows/deploy.yml@refs/heads/main"}).WorkflowFile())
+	assert.Equal(t, "ci.yaml", (&InputConfig{WorkflowRef: "owner/repo/.github/workflows/ci.yaml@refs/pull/1/merge"}).WorkflowFile())
+	assert.Equal(t, "", (&InputConfig{}).WorkflowFile())
+}
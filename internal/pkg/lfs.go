@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v66/github"
+)
+
+const (
+	// lfsPointerVersion is the first line of a Git LFS pointer file
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	// lfsPointerMaxSize is the size in bytes from which a blob is not read as a Git LFS pointer
+	lfsPointerMaxSize = 1024
+)
+
+var (
+	lfsOIDPattern  = regexp.MustCompile(`^oid sha256:([0-9a-f]{64})$`)
+	lfsSizePattern = regexp.MustCompile(`^size ([0-9]+)$`)
+	byteSizeUnits  = map[string]int64{"": 1, "B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+	byteSizeFormat = regexp.MustCompile(`^([0-9]+)\s*([A-Za-z]*)$`)
+)
+
+// LFSChange describes the change of a file stored with Git LFS. The old object ID is empty when the file was
+// added or was not stored with Git LFS in the base, the new one when the file was deleted or is no longer stored
+// with Git LFS.
+type LFSChange struct {
+	OldOID string `json:"old_oid,omitempty"`
+	NewOID string `json:"new_oid,omitempty"`
+}
+
+// lfsPointer is the object ID and size of a file stored with Git LFS, read from its pointer file
+type lfsPointer struct {
+	oid  string
+	size int64
+}
+
+// parseLFSPointer parses the content of a Git LFS pointer file.
+// Returns false when the content is not a pointer.
+func parseLFSPointer(content []byte) (*lfsPointer, bool) {
+	if len(content) > lfsPointerMaxSize || !bytes.HasPrefix(content, []byte(lfsPointerVersion+"\n")) {
+		return nil, false
+	}
+
+	pointer := &lfsPointer{size: -1}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := lfsOIDPattern.FindStringSubmatch(line); match != nil {
+			pointer.oid = match[1]
+		} else if match := lfsSizePattern.FindStringSubmatch(line); match != nil {
+			size, err := strconv.ParseInt(match[1], 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			pointer.size = size
+		}
+	}
+	if pointer.oid == "" || pointer.size < 0 {
+		return nil, false
+	}
+	return pointer, true
+}
+
+// setLFSPointers reports the change of the Git LFS objects of the old and new pointers, either nil when the side
+// is not a pointer, with the sizes of the objects instead of the sizes of the pointers. The lines of the pointers
+// are not counted when the file is stored with Git LFS on every side it exists.
+func setLFSPointers(fileChange *FileChange, oldPointer, newPointer *lfsPointer) {
+	if oldPointer == nil && newPointer == nil {
+		return
+	}
+
+	fileChange.LFS = &LFSChange{}
+	if oldPointer != nil {
+		fileChange.LFS.OldOID = oldPointer.oid
+		fileChange.OldSize = oldPointer.size
+	}
+	if newPointer != nil {
+		fileChange.LFS.NewOID = newPointer.oid
+		fileChange.NewSize = newPointer.size
+	}
+
+	if (oldPointer != nil || fileChange.OldHash == "") && (newPointer != nil || fileChange.NewHash == "") {
+		fileChange.Additions, fileChange.Deletions, fileChange.Changes = 0, 0, 0
+	}
+}
+
+// LFSChangedPaths returns the paths of the changes of files stored with Git LFS, in order.
+func LFSChangedPaths(changes []FileChange) []string {
+	paths := []string{}
+	for _, change := range changes {
+		if change.LFS != nil {
+			paths = append(paths, change.Path)
+		}
+	}
+	return paths
+}
+
+// FileSize returns the size in bytes of the file in the head, or in the base for a deleted file. The size of a
+// file stored with Git LFS is the size of its object.
+func FileSize(change FileChange) int64 {
+	if change.Status == FileDeleted {
+		return change.OldSize
+	}
+	return change.NewSize
+}
+
+// FilterFileSizes keeps the changes of the files whose size is at least minSize and at most maxSize bytes, zero
+// not limiting the size.
+func FilterFileSizes(changes []FileChange, minSize, maxSize int64) []FileChange {
+	if minSize == 0 && maxSize == 0 {
+		return changes
+	}
+
+	var filtered []FileChange
+	for _, change := range changes {
+		size := FileSize(change)
+		if size >= minSize && (maxSize == 0 || size <= maxSize) {
+			filtered = append(filtered, change)
+		}
+	}
+	return filtered
+}
+
+// parseByteSize parses a size in bytes with an optional binary unit, e.g. 512, 100KB or 1 GB. An empty string is
+// no size.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	match := byteSizeFormat.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	unit, found := byteSizeUnits[strings.ToUpper(match[2])]
+	if !found {
+		return 0, fmt.Errorf("invalid size unit '%s' in '%s', expected B, KB, MB, GB or TB", match[2], s)
+	}
+	size, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s': %v", s, err)
+	}
+	return size * unit, nil
+}
+
+// setGitFolderObjects sets the sizes of the blobs of the change, and reads the blobs small enough to be Git LFS
+// pointers. Submodules have no blobs.
+func setGitFolderObjects(fileChange *FileChange, change *object.Change) error {
+	from, to, err := change.Files()
+	if err != nil {
+		return err
+	}
+
+	readPointer := func(file *object.File) (*lfsPointer, int64, error) {
+		if file == nil {
+			return nil, 0, nil
+		}
+		if file.Size > lfsPointerMaxSize {
+			return nil, file.Size, nil
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return nil, 0, err
+		}
+		pointer, _ := parseLFSPointer([]byte(content))
+		return pointer, file.Size, nil
+	}
+
+	oldPointer, oldSize, err := readPointer(from)
+	if err != nil {
+		return err
+	}
+	newPointer, newSize, err := readPointer(to)
+	if err != nil {
+		return err
+	}
+	fileChange.OldSize, fileChange.NewSize = oldSize, newSize
+	setLFSPointers(fileChange, oldPointer, newPointer)
+	return nil
+}
+
+// setGitHubLFSPointers reads the blobs of the changes small enough to be Git LFS pointers, from their sizes in the
+// trees of both commits. Only the blobs of the paths with the filter=lfs attribute in the .gitattributes file at the
+// root of the current SHA are read, or every blob when the sizes are filtered. A blob that cannot be read is logged
+// and not taken as a pointer.
+func setGitHubLFSPointers(ctx context.Context, client *github.Client, cfg *InputConfig, fileChanges []FileChange) {
+	owner, repo := extractOwnerRepo(cfg.Repo)
+	minSize, maxSize := cfg.SizeLimits()
+	readAll := minSize > 0 || maxSize > 0
+
+	var matcher gitattributes.Matcher
+	if !readAll {
+		var err error
+		if matcher, err = getGitHubLFSMatcher(ctx, client, owner, repo, cfg.Sha); err != nil {
+			log.Printf("Warning: %v, not reading Git LFS pointers", err)
+			return
+		}
+		if matcher == nil {
+			return
+		}
+	}
+
+	readPointer := func(path, sha, mode string, size int64) *lfsPointer {
+		if sha == "" || size == 0 || size > lfsPointerMaxSize || mode == gitlinkMode {
+			return nil
+		}
+		content, _, err := client.Git.GetBlobRaw(ctx, owner, repo, sha)
+		if err != nil {
+			log.Printf("Warning: could not read blob %s of %s: %v", sha, path, err)
+			return nil
+		}
+		pointer, _ := parseLFSPointer(content)
+		return pointer
+	}
+
+	for i := range fileChanges {
+		change := &fileChanges[i]
+		if !readAll && !isLFSPath(matcher, change.Path) && !isLFSPath(matcher, change.PreviousPath) {
+			continue
+		}
+		oldPointer := readPointer(change.Path, change.OldHash, change.OldMode, change.OldSize)
+		newPointer := readPointer(change.Path, change.NewHash, change.NewMode, change.NewSize)
+		setLFSPointers(change, oldPointer, newPointer)
+	}
+}
+
+// getGitHubLFSMatcher reads the attributes of the .gitattributes file at the root of the SHA, to match the paths
+// stored with Git LFS. Returns nil when the file does not exist.
+func getGitHubLFSMatcher(ctx context.Context, client *github.Client, owner, repo, sha string) (gitattributes.Matcher, error) {
+	file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, ".gitattributes", &github.RepositoryContentGetOptions{Ref: sha})
+	if isGitHubNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving .gitattributes of %s: %v", sha, err)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("error decoding .gitattributes of %s: %v", sha, err)
+	}
+
+	attributes, err := gitattributes.ReadAttributes(strings.NewReader(content), nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing .gitattributes of %s: %v", sha, err)
+	}
+	return gitattributes.NewMatcher(attributes), nil
+}
+
+// isLFSPath reports whether the path has the filter=lfs attribute, i.e. is stored with Git LFS.
+func isLFSPath(matcher gitattributes.Matcher, path string) bool {
+	if path == "" {
+		return false
+	}
+	attributes, _ := matcher.Match(strings.Split(path, "/"), []string{"filter"})
+	filter, found := attributes["filter"]
+	return found && filter.IsValueSet() && filter.Value() == "lfs"
+}
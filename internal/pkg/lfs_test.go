@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testLFSPointer returns the content of a Git LFS pointer file to an object of the size, with an object ID made of
+// the digit.
+func testLFSPointer(digit string, size int64) string {
+	return fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, strings.Repeat(digit, 64), size)
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		content  string
+		expected *lfsPointer
+	}{
+		{
+			name:     "Pointer",
+			content:  testLFSPointer("a", 1048576),
+			expected: &lfsPointer{oid: strings.Repeat("a", 64), size: 1048576},
+		},
+		{
+			name:     "Pointer with extension",
+			content:  lfsPointerVersion + "\next-0-foo sha256:" + strings.Repeat("b", 64) + "\noid sha256:" + strings.Repeat("c", 64) + "\nsize 0\n",
+			expected: &lfsPointer{oid: strings.Repeat("c", 64), size: 0},
+		},
+		{
+			name:    "Text file",
+			content: "oid sha256:" + strings.Repeat("a", 64) + "\nsize 12\n",
+		},
+		{
+			name:    "Pointer without size",
+			content: lfsPointerVersion + "\noid sha256:" + strings.Repeat("a", 64) + "\n",
+		},
+		{
+			name:    "Pointer with a short object ID",
+			content: lfsPointerVersion + "\noid sha256:abc\nsize 12\n",
+		},
+		{
+			name:    "Too large",
+			content: testLFSPointer("a", 12) + strings.Repeat("\n", lfsPointerMaxSize),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			pointer, ok := parseLFSPointer([]byte(tc.content))
+			assert.Equal(t, tc.expected != nil, ok)
+			assert.Equal(t, tc.expected, pointer)
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{input: "", expected: 0},
+		{input: "512", expected: 512},
+		{input: "512B", expected: 512},
+		{input: "100kb", expected: 100 << 10},
+		{input: "1 MB", expected: 1 << 20},
+		{input: " 2GB ", expected: 2 << 30},
+		{input: "1TB", expected: 1 << 40},
+		{input: "1MiB", wantErr: true},
+		{input: "1.5MB", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "large", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			t.Parallel()
+			size, err := parseByteSize(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, size)
+		})
+	}
+}
+
+func TestFilterFileSizes(t *testing.T) {
+	t.Parallel()
+	changes := []FileChange{
+		{Path: "small.txt", Status: FileModified, OldSize: 2048, NewSize: 10},
+		{Path: "model.bin", Status: FileModified, OldSize: 10, NewSize: 5 << 20, LFS: &LFSChange{OldOID: "a", NewOID: "b"}},
+		{Path: "deleted.bin", Status: FileDeleted, OldSize: 2 << 20},
+		{Path: "modules", Status: FileModified, OldMode: gitlinkMode, NewMode: gitlinkMode},
+	}
+
+	tests := []struct {
+		name     string
+		minSize  int64
+		maxSize  int64
+		expected []string
+	}{
+		{name: "No limits", expected: []string{"small.txt", "model.bin", "deleted.bin", "modules"}},
+		{name: "Min size", minSize: 1 << 20, expected: []string{"model.bin", "deleted.bin"}},
+		{name: "Max size", maxSize: 1 << 20, expected: []string{"small.txt", "modules"}},
+		{name: "Min and max sizes", minSize: 1 << 20, maxSize: 4 << 20, expected: []string{"deleted.bin"}},
+		{name: "No match", minSize: 1 << 30, expected: []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, append([]string{}, FileChangePaths(FilterFileSizes(changes, tc.minSize, tc.maxSize))...))
+		})
+	}
+	assert.Equal(t, []string{"model.bin"}, LFSChangedPaths(changes))
+}
+
+func TestCompareGitFolderLFS(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t, map[string]string{
+		"model.bin":    testLFSPointer("a", 1048576),
+		"removed.bin":  testLFSPointer("b", 2048),
+		"migrated.csv": "a,b\n1,2\n",
+		"notes.txt":    "notes",
+	})
+	head := addTestCommit(t, repoPath, map[string]string{
+		"model.bin":    testLFSPointer("c", 3145728),
+		"removed.bin":  "",
+		"migrated.csv": testLFSPointer("d", 9),
+		"notes.txt":    "more notes",
+	})
+
+	result, err := CompareGitFolderSHAs(repoPath, shas[0], head, CompareOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	byPath := make(map[string]FileChange)
+	for _, change := range result {
+		byPath[change.Path] = change
+	}
+
+	model := byPath["model.bin"]
+	assert.Equal(t, &LFSChange{OldOID: strings.Repeat("a", 64), NewOID: strings.Repeat("c", 64)}, model.LFS)
+	assert.Equal(t, int64(1048576), model.OldSize)
+	assert.Equal(t, int64(3145728), model.NewSize)
+	assert.Equal(t, 0, model.Changes, "Lines of the pointers")
+
+	removed := byPath["removed.bin"]
+	assert.Equal(t, &LFSChange{OldOID: strings.Repeat("b", 64)}, removed.LFS)
+	assert.Equal(t, int64(2048), FileSize(removed))
+	assert.Equal(t, 0, removed.Changes, "Lines of the pointer")
+
+	// The lines of a file moved to Git LFS are still deleted
+	migrated := byPath["migrated.csv"]
+	assert.Equal(t, &LFSChange{NewOID: strings.Repeat("d", 64)}, migrated.LFS)
+	assert.Equal(t, int64(8), migrated.OldSize)
+	assert.Equal(t, int64(9), migrated.NewSize)
+	assert.Equal(t, 2, migrated.Deletions)
+
+	notes := byPath["notes.txt"]
+	assert.Nil(t, notes.LFS)
+	assert.Equal(t, int64(5), notes.OldSize)
+	assert.Equal(t, int64(10), notes.NewSize)
+	assert.Equal(t, 2, notes.Changes)
+
+	assert.Equal(t, []string{"model.bin"}, FileChangePaths(FilterFileSizes(result, 1<<20, 0)))
+}
+
+func TestCompareGithubLFS(t *testing.T) {
+	t.Parallel()
+	blobs := map[string]string{
+		"pointer1": testLFSPointer("a", 1048576),
+		"pointer2": testLFSPointer("b", 2097152),
+		"text3":    "small\n",
+	}
+	lfsModel := FileChange{Path: "model.bin", Status: FileModified, OldHash: "pointer1", NewHash: "pointer2", OldMode: "100644", NewMode: "100644", OldSize: 1048576, NewSize: 2097152, LFS: &LFSChange{OldOID: strings.Repeat("a", 64), NewOID: strings.Repeat("b", 64)}}
+	pointerModel := FileChange{Path: "model.bin", Status: FileModified, OldHash: "pointer1", NewHash: "pointer2", OldMode: "100644", NewMode: "100644", Additions: 2, Deletions: 2, Changes: 4, OldSize: 130, NewSize: 130}
+	large := FileChange{Path: "large.txt", Status: FileModified, OldHash: "text1", NewHash: "text2", OldMode: "100644", NewMode: "100644", Additions: 1, Changes: 1, OldSize: 4096, NewSize: 4097}
+	small := FileChange{Path: "small.txt", Status: FileAdded, NewHash: "text3", NewMode: "100644", Additions: 1, Changes: 1, NewSize: 6}
+
+	testCases := []struct {
+		name          string
+		gitattributes string
+		minSize       string
+		includes      []string
+		expectedBlobs []string
+		expected      []FileChange
+	}{
+		{
+			name:          "Paths stored with Git LFS",
+			gitattributes: "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+			expectedBlobs: []string{"pointer1", "pointer2"},
+			expected:      []FileChange{lfsModel, large, small},
+		},
+		{
+			name:     "Without .gitattributes",
+			expected: []FileChange{pointerModel, large, small},
+		},
+		{
+			name:          "Every small blob with size limits",
+			minSize:       "1",
+			expectedBlobs: []string{"pointer1", "pointer2", "text3"},
+			expected:      []FileChange{lfsModel, large, small},
+		},
+		{
+			name:          "Filtered out paths",
+			gitattributes: "*.bin filter=lfs\n*.txt filter=lfs\n",
+			includes:      []string{"*.txt"},
+			expectedBlobs: []string{"text3"},
+			expected:      []FileChange{large, small},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			client, mux, _ := setup(t)
+
+			mux.HandleFunc("/repos/owner/repo/compare/base123...head456", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"files": [
+					{"filename": "model.bin", "status": "modified", "sha": "pointer2", "additions": 2, "deletions": 2, "changes": 4},
+					{"filename": "large.txt", "status": "modified", "sha": "text2", "additions": 1, "changes": 1},
+					{"filename": "small.txt", "status": "added", "sha": "text3", "additions": 1, "changes": 1}
+				]}`)
+			})
+			mux.HandleFunc("/repos/owner/repo/git/trees/base123", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"tree": [
+					{"path": "model.bin", "type": "blob", "mode": "100644", "sha": "pointer1", "size": 130},
+					{"path": "large.txt", "type": "blob", "mode": "100644", "sha": "text1", "size": 4096}
+				]}`)
+			})
+			mux.HandleFunc("/repos/owner/repo/git/trees/head456", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"tree": [
+					{"path": "model.bin", "type": "blob", "mode": "100644", "sha": "pointer2", "size": 130},
+					{"path": "large.txt", "type": "blob", "mode": "100644", "sha": "text2", "size": 4097},
+					{"path": "small.txt", "type": "blob", "mode": "100644", "sha": "text3", "size": 6}
+				]}`)
+			})
+			mux.HandleFunc("/repos/owner/repo/contents/.gitattributes", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("ref") != "head456" {
+					t.Errorf("Expected .gitattributes of head456, got %s", r.URL.Query().Get("ref"))
+				}
+				if tc.gitattributes == "" {
+					http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+					return
+				}
+				fmt.Fprintf(w, `{"type": "file", "encoding": "base64", "content": "%s"}`, base64.StdEncoding.EncodeToString([]byte(tc.gitattributes)))
+			})
+			var requestedBlobs []string
+			var mu sync.Mutex
+			mux.HandleFunc("/repos/owner/repo/git/blobs/", func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Accept") != "application/vnd.github.v3.raw" {
+					t.Errorf("Expected a raw blob request, got Accept %s", r.Header.Get("Accept"))
+				}
+				sha := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/git/blobs/")
+				mu.Lock()
+				requestedBlobs = append(requestedBlobs, sha)
+				mu.Unlock()
+				fmt.Fprint(w, blobs[sha])
+			})
+
+			cfg := &InputConfig{Repo: "owner/repo", Sha: "head456", MinSize: tc.minSize, IncludesPatterns: tc.includes}
+			changes, err := CompareGithubSHAs(client, cfg, "base123")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			assert.Equal(t, tc.expected, changes)
+			assert.Equal(t, tc.expectedBlobs, requestedBlobs)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"log"
+	"slices"
+)
+
+// RecordDeployment records the current SHA as the latest deployment after a successful deploy. With
+// environment_source ref, the deployment references of the environments are pointed to it in the repository
+// at repoPath and pushed to the origin remote when record_push is set. With base_from_file, the SHA is written
+// to the base file of each environment. The "recorded_sha" output is set to the recorded SHA.
+func RecordDeployment(cfg *InputConfig, repoPath string) {
+	if cfg.IsEnvironmentFromRef() {
+		for _, environment := range cfg.EnvironmentList {
+			refName := cfg.DeploymentRefName(environment)
+			if err := SetGitFolderRef(repoPath, refName, cfg.Sha, cfg.IsRecordPush(), cfg.GithubToken); err != nil {
+				log.Panicf("Error recording deployment of %s: %v", environment, err)
+			}
+			log.Printf("Recorded deployment of %s: %s, SHA %s", environment, refName, cfg.Sha)
+		}
+	}
+
+	if cfg.BaseFromFile != "" {
+		environments := cfg.EnvironmentList
+		if len(environments) == 0 {
+			environments = []string{""}
+		}
+
+		// Write each base file once, the path may not depend on the environment
+		var paths []string
+		for _, environment := range environments {
+			if path := BaseFilePath(repoPath, cfg.BaseFromFile, environment); !slices.Contains(paths, path) {
+				paths = append(paths, path)
+			}
+		}
+		for _, path := range paths {
+			if err := WriteBaseFile(path, cfg.BaseFileSelector, cfg.Sha); err != nil {
+				log.Panicf("Error recording deployment to %s: %v", path, err)
+			}
+			log.Printf("Recorded deployment to %s, SHA %s", path, cfg.Sha)
+		}
+	}
+
+	SetGitHubOutput("recorded_sha", cfg.Sha)
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordDeployment(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"live/dev/main.tf": "dev", "live/prod/main.tf": "prod"},
+		map[string]string{"live/dev/main.tf": "dev v2"},
+		map[string]string{"live/prod/main.tf": "prod v2"},
+	)
+
+	// Record the deployment of the first commit to both environments
+	cfg := &InputConfig{
+		Sha:                 shas[0],
+		Environment:         "dev,prod",
+		EnvironmentList:     []string{"dev", "prod"},
+		EnvironmentSource:   "ref",
+		DeploymentRefPrefix: "refs/deployments/",
+	}
+	RecordDeployment(cfg, repoPath)
+
+	// Then only dev is deployed again
+	devCfg := *cfg
+	devCfg.Sha = shas[1]
+	devCfg.EnvironmentList = []string{"dev"}
+	RecordDeployment(&devCfg, repoPath)
+
+	// The deltas are calculated offline against the recorded deployments
+	cfg.Sha = shas[2]
+	for environment, expected := range map[string][]string{
+		"dev":  {"live/prod/main.tf"},
+		"prod": {"live/dev/main.tf", "live/prod/main.tf"},
+	} {
+		envCfg := *cfg
+		envCfg.Environment = environment
+		result := CalculateDelta(nil, &envCfg, repoPath, NewResolverChain(nil, &envCfg, repoPath))
+		assert.Equal(t, "environment", result.Base.Source)
+		assert.Equal(t, expected, result.Files, environment)
+	}
+}
+
+func TestRecordDeploymentToFile(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"live/dev/main.tf": "dev", "live/prod/main.tf": "prod"},
+		map[string]string{"live/prod/main.tf": "prod v2"},
+	)
+
+	// Record the deployment of the first commit to the state file of each environment
+	cfg := &InputConfig{
+		Sha:              shas[0],
+		Environment:      "dev,prod",
+		EnvironmentList:  []string{"dev", "prod"},
+		BaseFromFile:     ".deploy/{environment}.json",
+		BaseFileSelector: "sha",
+	}
+	RecordDeployment(cfg, repoPath)
+
+	// The delta is calculated against the recorded state file
+	cfg.Sha = shas[1]
+	cfg.Environment = "prod"
+	result := CalculateDelta(nil, cfg, repoPath, NewResolverChain(nil, cfg, repoPath))
+	assert.Equal(t, Base{SHA: shas[0], Source: "file"}, result.Base)
+	assert.Equal(t, []string{"live/prod/main.tf"}, result.Files)
+
+	sha, err := ReadBaseFile(BaseFilePath(repoPath, cfg.BaseFromFile, "dev"), "sha")
+	assert.NoError(t, err)
+	assert.Equal(t, shas[0], sha)
+}
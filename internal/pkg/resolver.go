@@ -0,0 +1,417 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v66/github"
+)
+
+const (
+	// ResolverArgSeparator separates a resolver kind from its argument in a base chain entry, e.g. "merge-base:main"
+	ResolverArgSeparator = ":"
+)
+
+// BaseResolver resolves the base commit SHA that the current SHA is compared against
+type BaseResolver interface {
+	// Name returns the source reported in the base_source output
+	Name() string
+	// Resolve returns the base SHA, or an empty string if no base could be found
+	Resolve() (string, error)
+}
+
+// HeadResolver is implemented by resolvers that also determine the head SHA to compare against the base
+type HeadResolver interface {
+	// ResolveHead returns the head SHA, or an empty string to keep the current SHA
+	ResolveHead() (string, error)
+}
+
+// Base holds the resolved base SHA, the name of the resolver that produced it and
+// the head SHA when the resolver determines it as well
+type Base struct {
+	SHA    string
+	Source string
+	Head   string
+}
+
+// ResolverChain tries each resolver in order until one of them returns a base SHA
+type ResolverChain []BaseResolver
+
+// Resolve returns the first base resolved by the chain. Errors from a resolver are logged and
+// the next resolver is tried. An empty Base is returned if no resolver could find a base, with an error
+// when a resolver failed, so that a failed lookup, e.g. an expired token, is not taken for a missing base.
+func (c ResolverChain) Resolve() (Base, error) {
+	var errs []error
+	for _, resolver := range c {
+		sha, err := resolver.Resolve()
+		if err != nil {
+			log.Printf("Error resolving base from %s: %v", resolver.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", resolver.Name(), err))
+			continue
+		}
+		if sha == "" {
+			log.Printf("No base found from %s, trying next resolver", resolver.Name())
+			continue
+		}
+		log.Printf("Resolved base %s from %s", sha, resolver.Name())
+		base := Base{SHA: sha, Source: resolver.Name()}
+		if headResolver, ok := resolver.(HeadResolver); ok {
+			base.Head, err = headResolver.ResolveHead()
+			if err != nil {
+				log.Printf("Error resolving head from %s: %v", resolver.Name(), err)
+			}
+		}
+		return base, nil
+	}
+	if len(errs) > 0 {
+		return Base{}, fmt.Errorf("no base could be resolved from the base chain: %w", errors.Join(errs...))
+	}
+	log.Println("No base could be resolved from the base chain")
+	return Base{}, nil
+}
+
+// HasResolver reports whether the chain holds a resolver reporting the source name, e.g. "environment".
+func (c ResolverChain) HasResolver(name string) bool {
+	return slices.ContainsFunc(c, func(resolver BaseResolver) bool {
+		return resolver.Name() == name
+	})
+}
+
+// resolverKinds lists the resolver kinds accepted in a base chain entry
+var resolverKinds = []string{"commit", "environment", "branch", "tag", "merge-base", "event", "workflow-run", "file"}
+
+// splitResolverEntry splits a base chain entry into the resolver kind and its optional argument.
+func splitResolverEntry(entry string) (kind, arg string) {
+	kind, arg, _ = strings.Cut(strings.TrimSpace(entry), ResolverArgSeparator)
+	return strings.TrimSpace(kind), strings.TrimSpace(arg)
+}
+
+// defaultBaseChain returns the base chain used when no base_chain input is given: the commit when set,
+// otherwise the base file when set, otherwise the environment when set, otherwise the branch.
+func defaultBaseChain(cfg *InputConfig) []string {
+	switch {
+	case cfg.Commit != "":
+		return []string{"commit"}
+	case cfg.BaseFromFile != "":
+		return []string{"file"}
+	case cfg.Environment != "":
+		return []string{"environment"}
+	default:
+		return []string{"branch"}
+	}
+}
+
+// NewResolverChain builds the resolver chain from the base_chain input, or from the
+// default chain when it is not given.
+func NewResolverChain(client *github.Client, cfg *InputConfig, repoPath string) ResolverChain {
+	entries := cfg.BaseChainEntries
+	if len(entries) == 0 {
+		entries = defaultBaseChain(cfg)
+	}
+
+	var chain ResolverChain
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		chain = append(chain, newResolver(client, cfg, repoPath, entry))
+	}
+	return chain
+}
+
+// newResolver creates the resolver for a single base chain entry. The argument of the entry
+// overrides the matching input, e.g. "branch:develop" resolves the develop branch.
+func newResolver(client *github.Client, cfg *InputConfig, repoPath, entry string) BaseResolver {
+	kind, arg := splitResolverEntry(entry)
+	switch kind {
+	case "commit":
+		return &commitResolver{client: client, cfg: cfg, repoPath: repoPath, commit: valueOr(arg, cfg.Commit)}
+	case "environment":
+		return &environmentResolver{client: client, cfg: cfg, repoPath: repoPath, environment: valueOr(arg, cfg.Environment)}
+	case "branch":
+		return &branchResolver{client: client, cfg: cfg, repoPath: repoPath, branch: valueOr(arg, cfg.Branch)}
+	case "tag":
+		return &tagResolver{client: client, cfg: cfg, repoPath: repoPath, pattern: valueOr(arg, cfg.BaseTagPattern)}
+	case "merge-base":
+		return &mergeBaseResolver{client: client, cfg: cfg, repoPath: repoPath, branch: valueOr(arg, cfg.Branch)}
+	case "event":
+		return &eventResolver{cfg: cfg}
+	case "workflow-run":
+		return &workflowRunResolver{client: client, cfg: cfg, branch: valueOr(arg, cfg.CurrentBranch())}
+	case "file":
+		return &fileResolver{cfg: cfg, repoPath: repoPath, path: valueOr(arg, cfg.BaseFromFile)}
+	}
+	log.Panicf("Unknown resolver '%s' in base_chain, expected one of %s", kind, strings.Join(resolverKinds, ", "))
+	return nil
+}
+
+// valueOr returns value if it is not empty, otherwise fallback.
+func valueOr(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// commitResolver resolves an explicit commit, offline in the local repository or online with the commits API
+type commitResolver struct {
+	client   *github.Client
+	cfg      *InputConfig
+	repoPath string
+	commit   string
+}
+
+func (r *commitResolver) Name() string {
+	return "commit"
+}
+
+func (r *commitResolver) Resolve() (string, error) {
+	if r.commit == "" {
+		return "", nil
+	}
+	if r.cfg.IsOnline() {
+		cfg := *r.cfg
+		cfg.Commit = r.commit
+		return GetGitHubCommitSHA(r.client, &cfg)
+	}
+	sha, err := GetGitFolderCommitSHA(r.repoPath, r.commit)
+	// A missing commit is no base, as online
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		log.Printf("Commit '%s' not found in the repository", r.commit)
+		return "", nil
+	}
+	return sha, err
+}
+
+// tagResolver resolves the highest semantic version tag matching a pattern that is an ancestor of the
+// current SHA, or a single tag when the pattern has no glob characters
+type tagResolver struct {
+	client   *github.Client
+	cfg      *InputConfig
+	repoPath string
+	pattern  string
+}
+
+func (r *tagResolver) Name() string {
+	return "tag"
+}
+
+func (r *tagResolver) Resolve() (string, error) {
+	if r.pattern == "" {
+		return "", nil
+	}
+	if !isTagPattern(r.pattern) {
+		return (&commitResolver{client: r.client, cfg: r.cfg, repoPath: r.repoPath, commit: "refs/tags/" + r.pattern}).Resolve()
+	}
+	if r.cfg.IsOnline() {
+		return GetGitHubLatestTagSHA(r.client, r.cfg, r.pattern)
+	}
+	return GetGitFolderLatestTagSHA(r.repoPath, r.pattern, r.cfg.Sha, r.cfg.IsSkipPrereleases())
+}
+
+// WithDeploymentShas makes the environment resolvers of the chain use the already looked up SHAs of the
+// latest successful deployments by environment, instead of listing the deployments again.
+func (c ResolverChain) WithDeploymentShas(deploymentShas map[string]string) ResolverChain {
+	for _, resolver := range c {
+		if r, ok := resolver.(*environmentResolver); ok {
+			r.deploymentShas = deploymentShas
+		}
+	}
+	return c
+}
+
+// environmentResolver resolves the SHA of the latest successful deployment of an environment, from the
+// deployments API or from the deployment reference in the local repository
+type environmentResolver struct {
+	client         *github.Client
+	cfg            *InputConfig
+	repoPath       string
+	environment    string
+	deploymentShas map[string]string
+}
+
+func (r *environmentResolver) Name() string {
+	return "environment"
+}
+
+func (r *environmentResolver) Resolve() (string, error) {
+	if r.environment == "" {
+		return "", nil
+	}
+	if r.cfg.IsEnvironmentFromRef() {
+		return GetGitFolderRefSHA(r.repoPath, r.cfg.DeploymentRefName(r.environment))
+	}
+	// Use the deployment already looked up together with the other environments
+	if r.deploymentShas != nil && slices.Contains(r.cfg.EnvironmentList, r.environment) {
+		return r.deploymentShas[r.environment], nil
+	}
+	cfg := *r.cfg
+	cfg.Environment = r.environment
+	return GetLatestSuccessfulDeploymentSha(r.client, &cfg)
+}
+
+// branchResolver resolves the latest commit of a branch, offline from the local references or online with the refs API
+type branchResolver struct {
+	client   *github.Client
+	cfg      *InputConfig
+	repoPath string
+	branch   string
+}
+
+func (r *branchResolver) Name() string {
+	return "branch"
+}
+
+func (r *branchResolver) Resolve() (string, error) {
+	if r.branch == "" {
+		return "", nil
+	}
+
+	// Look up the branch tip, then apply the suffix of a revision expression such as "main~2"
+	name, suffix := splitRevision(r.branch)
+	// The upstream of a branch is the branch on the origin remote, which is looked up first anyway
+	if branch, ok := parseUpstream(name); ok {
+		name = valueOr(branch, r.cfg.CurrentBranch())
+	}
+	sha, err := r.resolveBranch(name)
+	if err != nil {
+		return "", err
+	}
+
+	commit := &commitResolver{client: r.client, cfg: r.cfg, repoPath: r.repoPath, commit: r.branch}
+	switch {
+	case sha == "":
+		// Not a branch, resolve it as any other revision, e.g. a tag or an abbreviated SHA
+		return commit.Resolve()
+	case suffix != "":
+		commit.commit = sha + suffix
+		return commit.Resolve()
+	}
+	return sha, nil
+}
+
+// resolveBranch returns the latest commit of the branch, or an empty string if the branch does not exist.
+func (r *branchResolver) resolveBranch(branch string) (string, error) {
+	if branch == "" {
+		return "", nil
+	}
+	if !r.cfg.IsOnline() {
+		return GetGitFolderBranchLatestSHA(r.repoPath, branch)
+	}
+	cfg := *r.cfg
+	cfg.Branch = branch
+	return GetGitHubBranchLatestSHA(r.client, &cfg)
+}
+
+// mergeBaseResolver resolves the merge base between the latest commit of a branch and the current SHA
+type mergeBaseResolver struct {
+	client   *github.Client
+	cfg      *InputConfig
+	repoPath string
+	branch   string
+}
+
+func (r *mergeBaseResolver) Name() string {
+	return "merge-base"
+}
+
+func (r *mergeBaseResolver) Resolve() (string, error) {
+	branchSha, err := (&branchResolver{client: r.client, cfg: r.cfg, repoPath: r.repoPath, branch: r.branch}).Resolve()
+	if err != nil || branchSha == "" {
+		return "", err
+	}
+	return ResolveMergeBase(r.client, r.cfg, r.repoPath, branchSha)
+}
+
+// ResolveHeadSHA resolves the head input, any revision expression, to the SHA the delta is calculated for.
+// Returns the current SHA when no head is given and panics when the head cannot be resolved.
+func ResolveHeadSHA(client *github.Client, cfg *InputConfig, repoPath string) string {
+	if cfg.Head == "" {
+		return cfg.Sha
+	}
+	sha, err := (&commitResolver{client: client, cfg: cfg, repoPath: repoPath, commit: cfg.Head}).Resolve()
+	if err != nil || sha == "" {
+		log.Panicf("Could not resolve head '%s': %v", cfg.Head, err)
+	}
+	log.Printf("Resolved head %s to SHA %s", cfg.Head, sha)
+	return sha
+}
+
+// ResolveMergeBase returns the fork point of the base SHA and the current SHA, offline in the
+// local repository or online from the merge base commit returned by the compare API.
+func ResolveMergeBase(client *github.Client, cfg *InputConfig, repoPath, baseSha string) (string, error) {
+	if cfg.IsOnline() {
+		return GetGitHubMergeBaseSHA(client, cfg, baseSha)
+	}
+	deepenGitFolderHistory(cfg, repoPath, baseSha)
+	return GetGitFolderMergeBase(repoPath, baseSha, cfg.Sha)
+}
+
+// deepenGitFolderHistory fetches the history between the base SHA and the current SHA missing from a shallow
+// clone. A failure is only logged, as the commits may still be available without their merge base.
+func deepenGitFolderHistory(cfg *InputConfig, repoPath, baseSha string) {
+	if err := DeepenGitFolderHistory(repoPath, baseSha, cfg.Sha, cfg.DeepenMaxDepth, cfg.GithubToken); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// workflowRunResolver resolves the head SHA of the latest successful run of the current workflow on a branch
+type workflowRunResolver struct {
+	client *github.Client
+	cfg    *InputConfig
+	branch string
+}
+
+func (r *workflowRunResolver) Name() string {
+	return "workflow-run"
+}
+
+func (r *workflowRunResolver) Resolve() (string, error) {
+	if r.branch == "" || r.cfg.WorkflowFile() == "" {
+		return "", nil
+	}
+	return GetLatestSuccessfulWorkflowRunSha(r.client, r.cfg, r.branch)
+}
+
+// fileResolver resolves the last deployed SHA from a state file in the workspace
+type fileResolver struct {
+	cfg      *InputConfig
+	repoPath string
+	path     string
+}
+
+func (r *fileResolver) Name() string {
+	return "file"
+}
+
+func (r *fileResolver) Resolve() (string, error) {
+	if r.path == "" {
+		return "", nil
+	}
+	return ReadBaseFile(BaseFilePath(r.repoPath, r.path, r.cfg.Environment), r.cfg.BaseFileSelector)
+}
+
+// eventResolver resolves the base and head SHAs from the payload of the event that triggered the workflow
+type eventResolver struct {
+	cfg        *InputConfig
+	eventRange EventRange
+}
+
+func (r *eventResolver) Name() string {
+	return "event"
+}
+
+func (r *eventResolver) Resolve() (string, error) {
+	var err error
+	r.eventRange, err = GetEventRange(r.cfg.EventPath, r.cfg.EventName)
+	return r.eventRange.Base, err
+}
+
+// ResolveHead returns the head of the event range read by Resolve
+func (r *eventResolver) ResolveHead() (string, error) {
+	return r.eventRange.Head, nil
+}
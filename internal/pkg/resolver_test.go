@@ -0,0 +1,344 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubResolver is a BaseResolver returning a fixed result
+type stubResolver struct {
+	name string
+	sha  string
+	err  error
+}
+
+func (r *stubResolver) Name() string {
+	return r.name
+}
+
+func (r *stubResolver) Resolve() (string, error) {
+	return r.sha, r.err
+}
+
+func TestResolverChainResolve(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		chain    ResolverChain
+		expected Base
+		wantErr  bool
+	}{
+		{
+			name:     "First resolver wins",
+			chain:    ResolverChain{&stubResolver{name: "a", sha: "aaa"}, &stubResolver{name: "b", sha: "bbb"}},
+			expected: Base{SHA: "aaa", Source: "a"},
+		},
+		{
+			name:     "Falls back on empty base",
+			chain:    ResolverChain{&stubResolver{name: "a"}, &stubResolver{name: "b", sha: "bbb"}},
+			expected: Base{SHA: "bbb", Source: "b"},
+		},
+		{
+			name:     "Falls back on error",
+			chain:    ResolverChain{&stubResolver{name: "a", sha: "aaa", err: errors.New("boom")}, &stubResolver{name: "b", sha: "bbb"}},
+			expected: Base{SHA: "bbb", Source: "b"},
+		},
+		{
+			name:     "Nothing resolved",
+			chain:    ResolverChain{&stubResolver{name: "a"}},
+			expected: Base{},
+		},
+		{
+			name:     "Nothing resolved after an error",
+			chain:    ResolverChain{&stubResolver{name: "a", err: errors.New("boom")}, &stubResolver{name: "b"}},
+			expected: Base{},
+			wantErr:  true,
+		},
+		{
+			name:     "Empty chain",
+			chain:    ResolverChain{},
+			expected: Base{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := tt.chain.Resolve()
+			assert.Equal(t, tt.wantErr, err != nil, "Error %v", err)
+			assert.Equal(t, tt.expected, base)
+		})
+	}
+}
+
+func TestNewResolverChain(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		cfg           InputConfig
+		expectedNames []string
+	}{
+		{
+			name:          "Default chain with commit",
+			cfg:           InputConfig{Commit: "abc", Environment: "prod", Branch: "main"},
+			expectedNames: []string{"commit"},
+		},
+		{
+			name:          "Default chain with base file",
+			cfg:           InputConfig{BaseFromFile: ".deploy/prod.sha", Environment: "prod", Branch: "main"},
+			expectedNames: []string{"file"},
+		},
+		{
+			name:          "Default chain with environment",
+			cfg:           InputConfig{Environment: "prod", Branch: "main"},
+			expectedNames: []string{"environment"},
+		},
+		{
+			name:          "Default chain with branch",
+			cfg:           InputConfig{Branch: "main"},
+			expectedNames: []string{"branch"},
+		},
+		{
+			name:          "Configured chain",
+			cfg:           InputConfig{BaseChainEntries: []string{"environment", "merge-base:main", "", "commit:HEAD~1", "tag:v1.0.0"}},
+			expectedNames: []string{"environment", "merge-base", "commit", "tag"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := NewResolverChain(nil, &tt.cfg, ".")
+			var names []string
+			for _, resolver := range chain {
+				names = append(names, resolver.Name())
+			}
+			assert.Equal(t, tt.expectedNames, names)
+		})
+	}
+
+	assert.Panics(t, func() { NewResolverChain(nil, &InputConfig{BaseChainEntries: []string{"unknown"}}, ".") })
+
+	assert.True(t, NewResolverChain(nil, &InputConfig{Environment: "dev,prod"}, ".").HasResolver("environment"))
+	assert.False(t, NewResolverChain(nil, &InputConfig{Environment: "dev,prod", Commit: "HEAD~1"}, ".").HasResolver("environment"))
+	assert.False(t, NewResolverChain(nil, &InputConfig{Environment: "dev,prod", BaseChainEntries: []string{"tag:v*", "branch"}}, ".").HasResolver("environment"))
+}
+
+func TestResolverChainOffline(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+		map[string]string{"c.txt": "c"},
+	)
+	client, mux, _ := setup(t)
+
+	// The environment has never been deployed
+	mux.HandleFunc("/repos/owner/repo/deployments", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	cfg := &InputConfig{
+		Repo:             "owner/repo",
+		Sha:              shas[2],
+		Environment:      "prod",
+		BaseChainEntries: []string{"environment", "tag:missing", "commit:HEAD~1"},
+	}
+
+	base, err := NewResolverChain(client, cfg, repoPath).Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, Base{SHA: shas[1], Source: "commit"}, base)
+}
+
+func TestResolverChainEnvironmentError(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+	)
+	client, mux, _ := setup(t)
+
+	// The deployments cannot be listed, e.g. with a token missing the deployments permission
+	mux.HandleFunc("/repos/owner/repo/deployments", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Resource not accessible by integration"}`, http.StatusForbidden)
+	})
+
+	cfg := &InputConfig{
+		Repo:             "owner/repo",
+		Sha:              shas[1],
+		Environment:      "prod",
+		BaseChainEntries: []string{"environment", "commit:HEAD~1"},
+	}
+
+	_, err := (&environmentResolver{client: client, cfg: cfg, environment: "prod"}).Resolve()
+	assert.Error(t, err)
+	base, err := NewResolverChain(client, cfg, repoPath).Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, Base{SHA: shas[0], Source: "commit"}, base)
+}
+
+func TestResolverChainDeploymentStatusError(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	mux.HandleFunc("/repos/owner/repo/deployments", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 1, "sha": "abc123"}]`)
+	})
+	mux.HandleFunc("/repos/owner/repo/deployments/1/statuses", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "API rate limit exceeded"}`, http.StatusForbidden)
+	})
+
+	cfg := &InputConfig{Repo: "owner/repo", Sha: "head456", Environment: "prod", BaseChainEntries: []string{"environment"}}
+	base, err := NewResolverChain(client, cfg, ".").Resolve()
+	assert.Error(t, err)
+	assert.Equal(t, Base{}, base)
+}
+
+func TestResolveMergeBaseOffline(t *testing.T) {
+	t.Parallel()
+	// main: c0 -> c1, feature: c0 -> c2
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"main.txt": "main"},
+	)
+	checkoutTestCommit(t, repoPath, shas[0])
+	head := addTestCommit(t, repoPath, map[string]string{"feature.txt": "feature"})
+
+	cfg := &InputConfig{Sha: head}
+	mergeBase, err := ResolveMergeBase(nil, cfg, repoPath, shas[1])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, shas[0], mergeBase)
+
+	// Only the changes of the feature branch are reported from the merge base
+	diffs, err := CompareGitFolderSHAs(repoPath, mergeBase, head, CompareOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, []string{"feature.txt"}, FileChangePaths(diffs))
+}
+
+func TestResolverChainBranchOffline(t *testing.T) {
+	t.Parallel()
+	// origin/main: c0 -> c1, feature: c0 -> c2
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"main.txt": "main"},
+	)
+	checkoutTestCommit(t, repoPath, shas[0])
+	head := addTestCommit(t, repoPath, map[string]string{"feature.txt": "feature"})
+	packedRefs := shas[1] + " refs/remotes/origin/main\n"
+	if err := os.WriteFile(filepath.Join(repoPath, ".git", "packed-refs"), []byte(packedRefs), 0644); err != nil {
+		t.Fatalf("Error writing packed references: %v", err)
+	}
+
+	// No client is needed offline
+	cfg := &InputConfig{Sha: head, Branch: "main", BaseChainEntries: []string{"branch"}}
+	base, err := NewResolverChain(nil, cfg, repoPath).Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, Base{SHA: shas[1], Source: "branch"}, base)
+
+	cfg.BaseChainEntries = []string{"merge-base"}
+	base, err = NewResolverChain(nil, cfg, repoPath).Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, Base{SHA: shas[0], Source: "merge-base"}, base)
+}
+
+func TestResolverChainRevisionsOffline(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+		map[string]string{"c.txt": "c"},
+	)
+	addTestTag(t, repoPath, "v1.0.0", shas[0], true)
+	packedRefs := shas[2] + " refs/remotes/origin/main\n"
+	if err := os.WriteFile(filepath.Join(repoPath, ".git", "packed-refs"), []byte(packedRefs), 0644); err != nil {
+		t.Fatalf("Error writing packed references: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		entry    string
+		expected Base
+	}{
+		{name: "Branch with suffix", entry: "branch:main~1", expected: Base{SHA: shas[1], Source: "branch"}},
+		{name: "Branch upstream", entry: "branch:main@{upstream}^", expected: Base{SHA: shas[1], Source: "branch"}},
+		{name: "Branch given as tag", entry: "branch:v1.0.0^{commit}", expected: Base{SHA: shas[0], Source: "branch"}},
+		{name: "Commit relative to HEAD", entry: "commit:HEAD~2", expected: Base{SHA: shas[0], Source: "commit"}},
+		{name: "Unknown branch", entry: "branch:unknown", expected: Base{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &InputConfig{Sha: shas[2], BaseChainEntries: []string{tc.entry}}
+			base, err := NewResolverChain(nil, cfg, repoPath).Resolve()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, base)
+		})
+	}
+}
+
+func TestResolveHeadSHA(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+	)
+
+	cfg := &InputConfig{Sha: shas[1]}
+	assert.Equal(t, shas[1], ResolveHeadSHA(nil, cfg, repoPath))
+
+	cfg.Head = "HEAD~1"
+	assert.Equal(t, shas[0], ResolveHeadSHA(nil, cfg, repoPath))
+
+	cfg.Head = "unknown"
+	assert.Panics(t, func() { ResolveHeadSHA(nil, cfg, repoPath) })
+}
+
+func TestResolveMergeBaseOnline(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// Mock the CompareCommits endpoint
+	mux.HandleFunc("/repos/owner/repo/compare/main123...head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"merge_base_commit": {"sha": "fork789"}}`)
+	})
+
+	cfg := &InputConfig{Repo: "owner/repo", Sha: "head456", Online: "true"}
+	mergeBase, err := ResolveMergeBase(client, cfg, ".", "main123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, "fork789", mergeBase)
+}
+
+func TestResolverChainEvent(t *testing.T) {
+	t.Parallel()
+	eventPath := filepath.Join(t.TempDir(), "event.json")
+	if err := os.WriteFile(eventPath, []byte(`{"before": "0000000000000000000000000000000000000000", "after": "after456"}`), 0600); err != nil {
+		t.Fatalf("Error writing event payload: %v", err)
+	}
+	pushPath := filepath.Join(t.TempDir(), "push.json")
+	if err := os.WriteFile(pushPath, []byte(`{"before": "before123", "after": "after456"}`), 0600); err != nil {
+		t.Fatalf("Error writing event payload: %v", err)
+	}
+
+	// A push creating a branch has no base, so the chain falls back to the next resolver
+	cfg := &InputConfig{EventName: "push", EventPath: eventPath, BaseChainEntries: []string{"event"}}
+	chain := append(NewResolverChain(nil, cfg, "."), &stubResolver{name: "commit", sha: "abc"})
+	base, err := chain.Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, Base{SHA: "abc", Source: "commit"}, base)
+
+	// A push with a previous commit resolves both base and head
+	cfg.EventPath = pushPath
+	base, err = NewResolverChain(nil, cfg, ".").Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, Base{SHA: "before123", Source: "event", Head: "after456"}, base)
+}
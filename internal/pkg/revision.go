@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// revisionStep is one navigation step of a revision suffix: the nth parent for "^n", or the nth
+// first-parent ancestor for "~n"
+type revisionStep struct {
+	ancestor bool
+	n        int
+}
+
+// upstreamRegexp matches a revision naming the upstream of a branch, e.g. "main@{upstream}" or "@{u}"
+var upstreamRegexp = regexp.MustCompile(`^(.*)@\{(?i:upstream|u)\}$`)
+
+// splitRevision splits a revision expression into its starting point and its navigation suffix,
+// e.g. "main~2^2" into "main" and "~2^2".
+func splitRevision(rev string) (string, string) {
+	i := strings.IndexAny(rev, "~^")
+	if i < 0 {
+		return rev, ""
+	}
+	return rev[:i], rev[i:]
+}
+
+// parseUpstream returns the branch of a revision naming the upstream of a branch, empty for the
+// current branch. Returns false if the revision does not name an upstream.
+func parseUpstream(name string) (string, bool) {
+	match := upstreamRegexp.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// parseRevisionSuffix parses the navigation suffix of a revision expression into steps, e.g. "~2^2".
+// "^{}" and "^{commit}" peel to the commit and need no step. Returns an error for other suffixes
+// such as "^{/message}".
+func parseRevisionSuffix(suffix string) ([]revisionStep, error) {
+	var steps []revisionStep
+	for suffix != "" {
+		op := suffix[0]
+		if op != '~' && op != '^' {
+			return nil, fmt.Errorf("unsupported revision suffix %s", suffix)
+		}
+		suffix = suffix[1:]
+
+		if op == '^' && strings.HasPrefix(suffix, "{") {
+			end := strings.Index(suffix, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated revision suffix ^%s", suffix)
+			}
+			if peel := suffix[1:end]; peel != "" && peel != "commit" {
+				return nil, fmt.Errorf("unsupported revision suffix ^%s", suffix[:end+1])
+			}
+			suffix = suffix[end+1:]
+			continue
+		}
+
+		// The number defaults to one, e.g. "HEAD^" or "HEAD~"
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		n := 1
+		if digits > 0 {
+			var err error
+			if n, err = strconv.Atoi(suffix[:digits]); err != nil {
+				return nil, fmt.Errorf("invalid revision suffix %c%s: %v", op, suffix[:digits], err)
+			}
+		}
+		suffix = suffix[digits:]
+		steps = append(steps, revisionStep{ancestor: op == '~', n: n})
+	}
+	return steps, nil
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitRevision(t *testing.T) {
+	testCases := []struct {
+		rev            string
+		expectedName   string
+		expectedSuffix string
+	}{
+		{rev: "main", expectedName: "main"},
+		{rev: "HEAD~5", expectedName: "HEAD", expectedSuffix: "~5"},
+		{rev: "main~2^2", expectedName: "main", expectedSuffix: "~2^2"},
+		{rev: "v1.2.0^{commit}", expectedName: "v1.2.0", expectedSuffix: "^{commit}"},
+		{rev: "main@{upstream}~1", expectedName: "main@{upstream}", expectedSuffix: "~1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.rev, func(t *testing.T) {
+			name, suffix := splitRevision(tc.rev)
+			assert.Equal(t, tc.expectedName, name)
+			assert.Equal(t, tc.expectedSuffix, suffix)
+		})
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	testCases := []struct {
+		name           string
+		expectedBranch string
+		expectedOk     bool
+	}{
+		{name: "main@{upstream}", expectedBranch: "main", expectedOk: true},
+		{name: "feature/a@{u}", expectedBranch: "feature/a", expectedOk: true},
+		{name: "@{U}", expectedBranch: "", expectedOk: true},
+		{name: "main@{push}", expectedOk: false},
+		{name: "main", expectedOk: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			branch, ok := parseUpstream(tc.name)
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expectedBranch, branch)
+		})
+	}
+}
+
+func TestParseRevisionSuffix(t *testing.T) {
+	testCases := []struct {
+		suffix   string
+		expected []revisionStep
+		wantErr  bool
+	}{
+		{suffix: "", expected: nil},
+		{suffix: "~", expected: []revisionStep{{ancestor: true, n: 1}}},
+		{suffix: "~5", expected: []revisionStep{{ancestor: true, n: 5}}},
+		{suffix: "^", expected: []revisionStep{{n: 1}}},
+		{suffix: "^2~3", expected: []revisionStep{{n: 2}, {ancestor: true, n: 3}}},
+		{suffix: "^0", expected: []revisionStep{{n: 0}}},
+		{suffix: "^{commit}~1", expected: []revisionStep{{ancestor: true, n: 1}}},
+		{suffix: "^{}", expected: nil},
+		{suffix: "^{/fix}", wantErr: true},
+		{suffix: "^{commit", wantErr: true},
+		{suffix: "~1x", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.suffix, func(t *testing.T) {
+			steps, err := parseRevisionSuffix(tc.suffix)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, steps)
+		})
+	}
+}
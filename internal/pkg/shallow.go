@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	// initialDeepenDepth is the depth of the first fetch deepening a shallow clone, doubled on every attempt
+	initialDeepenDepth = 32
+	// deepenRefPrefix holds the temporary references of the commits fetched by their SHA
+	deepenRefPrefix = "refs/git-delta/"
+)
+
+// DeepenGitFolderHistory fetches the missing history of a shallow clone from the origin remote until both
+// commits and their merge base are available. The commits are fetched by their SHA with a depth doubled on
+// every attempt, up to maxDepth, or with the branches of the remote when it does not serve commits by SHA.
+// It does nothing for a full clone, when the history is already available, or when maxDepth is zero.
+// Returns an error if the history is still missing after fetching maxDepth commits.
+func DeepenGitFolderHistory(repoPath, sha1, sha2 string, maxDepth int, token string) error {
+	// Open the repository at the given path
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("could not open repository: %v", err)
+	}
+
+	shallows, err := repo.Storer.Shallow()
+	if err != nil {
+		return fmt.Errorf("could not read shallow commits: %v", err)
+	}
+	if len(shallows) == 0 || maxDepth == 0 || hasGitFolderHistory(repo, sha1, sha2) {
+		return nil
+	}
+
+	// Fetch the commits themselves, so that a base missing from the branches can be found too
+	var refSpecs []config.RefSpec
+	for _, sha := range []string{sha1, sha2} {
+		if plumbing.IsHash(sha) {
+			refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("+%s:%s%s", sha, deepenRefPrefix, sha)))
+		}
+	}
+	defer removeDeepenRefs(repo, refSpecs)
+
+	for depth := min(initialDeepenDepth, maxDepth); ; depth = min(depth*2, maxDepth) {
+		log.Printf("Shallow clone misses the history between %s and %s, fetching %d commits from %s", sha1, sha2, depth, git.DefaultRemoteName)
+		err := fetchGitFolderHistory(repo, refSpecs, depth, token)
+		if errors.Is(err, git.ErrExactSHA1NotSupported) {
+			log.Printf("Remote %s does not serve commits by SHA, fetching its branches instead", git.DefaultRemoteName)
+			refSpecs = nil
+			err = fetchGitFolderHistory(repo, refSpecs, depth, token)
+		}
+		if err != nil {
+			return err
+		}
+
+		if hasGitFolderHistory(repo, sha1, sha2) {
+			return nil
+		}
+		if depth == maxDepth {
+			return fmt.Errorf("history between %s and %s is still missing after fetching %d commits, fetch the entire history with fetch-depth: 0", sha1, sha2, maxDepth)
+		}
+	}
+}
+
+// IsGitFolderShallow reports whether the repository is a shallow clone missing part of its history.
+func IsGitFolderShallow(repoPath string) (bool, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("could not open repository: %v", err)
+	}
+	shallows, err := repo.Storer.Shallow()
+	if err != nil {
+		return false, fmt.Errorf("could not read shallow commits: %v", err)
+	}
+	return len(shallows) > 0, nil
+}
+
+// hasGitFolderHistory reports whether both commits and their merge base are available in the repository.
+// The empty tree needs no history.
+func hasGitFolderHistory(repo *git.Repository, sha1, sha2 string) bool {
+	commit2, err := getGitFolderCommit(repo, sha2)
+	if err != nil {
+		return false
+	}
+	if sha1 == EmptyTreeSHA {
+		return true
+	}
+	commit1, err := getGitFolderCommit(repo, sha1)
+	if err != nil {
+		return false
+	}
+
+	// Walking a truncated history fails on the first missing parent
+	bases, err := commit1.MergeBase(commit2)
+	return err == nil && len(bases) > 0
+}
+
+// fetchGitFolderHistory fetches the given references from the origin remote with the given depth, or its
+// branches without references. The shallow commits whose parents are now available are unmarked.
+func fetchGitFolderHistory(repo *git.Repository, refSpecs []config.RefSpec, depth int, token string) error {
+	opts := &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   refSpecs,
+		Depth:      depth,
+		Auth:       gitFolderAuth(token),
+		Tags:       git.NoTags,
+	}
+	if err := repo.Fetch(opts); err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("could not fetch history from %s: %w", git.DefaultRemoteName, err)
+	}
+	return pruneGitFolderShallows(repo)
+}
+
+// pruneGitFolderShallows unmarks the shallow commits whose parents are all available, as fetching deeper
+// history adds the new shallow commits but keeps the previous ones.
+func pruneGitFolderShallows(repo *git.Repository) error {
+	shallows, err := repo.Storer.Shallow()
+	if err != nil {
+		return fmt.Errorf("could not read shallow commits: %v", err)
+	}
+
+	var kept []plumbing.Hash
+	for _, hash := range shallows {
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			kept = append(kept, hash)
+			continue
+		}
+		for _, parent := range commit.ParentHashes {
+			if _, err := repo.CommitObject(parent); err != nil {
+				kept = append(kept, hash)
+				break
+			}
+		}
+	}
+
+	if err := repo.Storer.SetShallow(kept); err != nil {
+		return fmt.Errorf("could not write shallow commits: %v", err)
+	}
+	return nil
+}
+
+// removeDeepenRefs removes the temporary references created to fetch commits by their SHA.
+func removeDeepenRefs(repo *git.Repository, refSpecs []config.RefSpec) {
+	for _, refSpec := range refSpecs {
+		if err := repo.Storer.RemoveReference(refSpec.Dst("")); err != nil {
+			log.Printf("Error removing reference %s: %v", refSpec.Dst(""), err)
+		}
+	}
+}
+
+// gitFolderAuth returns the authentication for the origin remote, or nil without token.
+func gitFolderAuth(token string) transport.AuthMethod {
+	if token == "" {
+		return nil
+	}
+	return &http.BasicAuth{Username: "x-access-token", Password: token}
+}
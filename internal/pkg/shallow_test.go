@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// newTestShallowClone clones the repository into a local bare remote, serving commits by SHA when allowSHA is
+// set, and shallow clones the remote with a depth of one. Returns the path of the shallow clone.
+func newTestShallowClone(t *testing.T, repoPath string, allowSHA bool) string {
+	t.Helper()
+	remotePath := t.TempDir()
+	remote, err := git.PlainClone(remotePath, true, &git.CloneOptions{URL: repoPath})
+	if err != nil {
+		t.Fatalf("Error cloning remote repository: %v", err)
+	}
+	if allowSHA {
+		remoteConfig, err := remote.Config()
+		if err != nil {
+			t.Fatalf("Error reading remote config: %v", err)
+		}
+		remoteConfig.Raw.Section("uploadpack").SetOption("allowReachableSHA1InWant", "true")
+		if err := remote.SetConfig(remoteConfig); err != nil {
+			t.Fatalf("Error writing remote config: %v", err)
+		}
+	}
+
+	clonePath := t.TempDir()
+	if _, err := git.PlainClone(clonePath, false, &git.CloneOptions{URL: remotePath, Depth: 1}); err != nil {
+		t.Fatalf("Error shallow cloning repository: %v", err)
+	}
+	return clonePath
+}
+
+func TestDeepenGitFolderHistory(t *testing.T) {
+	t.Parallel()
+	// main: c0 -> ... -> c39, branch: c0 -> c1 -> b
+	commits := make([]map[string]string, 40)
+	for i := range commits {
+		commits[i] = map[string]string{"main.txt": string(rune('a' + i))}
+	}
+	repoPath, shas := newTestRepo(t, commits...)
+	checkoutTestCommit(t, repoPath, shas[1])
+	branchSha := addTestCommit(t, repoPath, map[string]string{"branch.txt": "branch"})
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	// Keep master checked out, so that the clones start from its head
+	for _, ref := range []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/branch", plumbing.NewHash(branchSha)),
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master),
+	} {
+		if err := repo.Storer.SetReference(ref); err != nil {
+			t.Fatalf("Error setting reference %s: %v", ref.Name(), err)
+		}
+	}
+	head := shas[len(shas)-1]
+
+	testCases := []struct {
+		name     string
+		allowSHA bool
+		base     string
+		maxDepth int
+		wantErr  bool
+	}{
+		{name: "Base on the same branch", allowSHA: true, base: shas[2], maxDepth: 1024},
+		{name: "Base only reachable from another branch", allowSHA: true, base: branchSha, maxDepth: 1024},
+		{name: "Remote without commits by SHA", allowSHA: false, base: shas[2], maxDepth: 1024},
+		{name: "History deeper than the maximum depth", allowSHA: true, base: shas[2], maxDepth: 8, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			clonePath := newTestShallowClone(t, repoPath, tc.allowSHA)
+
+			if _, err := CompareGitFolderSHAs(clonePath, tc.base, head, CompareOptions{}); err == nil {
+				t.Errorf("Expected an error before deepening, but got nil")
+			}
+
+			err := DeepenGitFolderHistory(clonePath, tc.base, head, tc.maxDepth, "")
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			mergeBase, err := GetGitFolderMergeBase(clonePath, tc.base, head)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := CompareGitFolderSHAs(clonePath, mergeBase, head, CompareOptions{}); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			// The temporary references are removed and the unmarked shallow commits are complete
+			clone, err := git.PlainOpen(clonePath)
+			if err != nil {
+				t.Fatalf("Error opening repository: %v", err)
+			}
+			if _, err := clone.Reference(plumbing.ReferenceName(deepenRefPrefix+tc.base), false); err != plumbing.ErrReferenceNotFound {
+				t.Errorf("Expected the temporary reference to be removed, got %v", err)
+			}
+			shallows, err := clone.Storer.Shallow()
+			if err != nil {
+				t.Fatalf("Error reading shallow commits: %v", err)
+			}
+			for _, hash := range shallows {
+				if hash.String() == head {
+					t.Errorf("Expected the head to be unmarked as shallow")
+				}
+			}
+		})
+	}
+}
+
+func TestDeepenGitFolderHistoryFullClone(t *testing.T) {
+	t.Parallel()
+	repoPath, shas := newTestRepo(t,
+		map[string]string{"a.txt": "a"},
+		map[string]string{"b.txt": "b"},
+	)
+
+	// A full clone without remote needs no fetch
+	if err := DeepenGitFolderHistory(repoPath, shas[0], shas[1], 1024, ""); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EnvironmentPlaceholder is replaced with the environment name in the base file path, e.g. ".deploy/{environment}.sha"
+	EnvironmentPlaceholder = "{environment}"
+)
+
+// BaseFilePath returns the path of the base file for the environment, relative paths being resolved against repoPath.
+func BaseFilePath(repoPath, path, environment string) string {
+	path = strings.ReplaceAll(path, EnvironmentPlaceholder, environment)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(repoPath, path)
+}
+
+// ReadBaseFile reads the last deployed SHA from a state file. Without a selector, the file holds the SHA as plain
+// text. With a selector, the file is a JSON document and the selector is the dot separated path to the SHA, e.g.
+// "prod.sha" or "deployments.0.sha". Returns an empty string if the file or the selected field does not exist.
+func ReadBaseFile(path, selector string) (string, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read base file: %v", err)
+	}
+
+	if selector == "" {
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	var data any
+	if err := json.Unmarshal(content, &data); err != nil {
+		return "", fmt.Errorf("could not parse base file %s as JSON: %v", path, err)
+	}
+	value, ok := lookupJSONPath(data, selector)
+	if !ok || value == nil {
+		return "", nil
+	}
+	sha, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("value at %s in base file %s is not a string", selector, path)
+	}
+	return strings.TrimSpace(sha), nil
+}
+
+// WriteBaseFile writes the SHA to a state file, as plain text without a selector, or into the JSON document at the
+// selector path otherwise, keeping the other fields of the document. Missing files and objects are created.
+func WriteBaseFile(path, selector, sha string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create folder for base file: %v", err)
+	}
+
+	if selector == "" {
+		if err := os.WriteFile(path, []byte(sha+"\n"), 0644); err != nil {
+			return fmt.Errorf("could not write base file: %v", err)
+		}
+		return nil
+	}
+
+	// Load the existing document to keep its other fields
+	var data any
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read base file: %v", err)
+	}
+	if len(strings.TrimSpace(string(content))) > 0 {
+		if err := json.Unmarshal(content, &data); err != nil {
+			return fmt.Errorf("could not parse base file %s as JSON: %v", path, err)
+		}
+	}
+
+	data, err = setJSONPath(data, strings.Split(selector, "."), sha)
+	if err != nil {
+		return fmt.Errorf("could not set %s in base file %s: %v", selector, path, err)
+	}
+
+	content, err = json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode base file: %v", err)
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("could not write base file: %v", err)
+	}
+	return nil
+}
+
+// setJSONPath sets the value at the path in the decoded JSON data and returns the updated data.
+// Missing objects along the path are created, array indexes must exist.
+func setJSONPath(data any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	key := path[0]
+	switch node := data.(type) {
+	case nil:
+		child, err := setJSONPath(nil, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{key: child}, nil
+	case map[string]any:
+		child, err := setJSONPath(node[key], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[key] = child
+		return node, nil
+	case []any:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= len(node) {
+			return nil, fmt.Errorf("index %s is out of range", key)
+		}
+		child, err := setJSONPath(node[index], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = child
+		return node, nil
+	}
+	return nil, fmt.Errorf("%s is not an object or array", key)
+}
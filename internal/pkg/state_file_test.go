@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseFilePath(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, filepath.Join("repo", ".deploy", "prod.sha"), BaseFilePath("repo", ".deploy/{environment}.sha", "prod"))
+	assert.Equal(t, "/state/prod.json", BaseFilePath("repo", "/state/{environment}.json", "prod"))
+}
+
+func TestReadBaseFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	files := map[string]string{
+		"plain.sha":    "abc123\n",
+		"state.json":   `{"prod": {"sha": "def456"}, "deployments": [{"sha": "ghi789"}], "count": 3, "empty": null}`,
+		"invalid.json": "not json",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		file          string
+		selector      string
+		expected      string
+		expectedError bool
+	}{
+		{name: "Plain text", file: "plain.sha", expected: "abc123"},
+		{name: "JSON object", file: "state.json", selector: "prod.sha", expected: "def456"},
+		{name: "JSON array", file: "state.json", selector: "deployments.0.sha", expected: "ghi789"},
+		{name: "Missing field", file: "state.json", selector: "staging.sha", expected: ""},
+		{name: "Null field", file: "state.json", selector: "empty", expected: ""},
+		{name: "Missing file", file: "missing.sha", expected: ""},
+		{name: "Not a string", file: "state.json", selector: "count", expectedError: true},
+		{name: "Invalid JSON", file: "invalid.json", selector: "sha", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sha, err := ReadBaseFile(filepath.Join(dir, tt.file), tt.selector)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, sha)
+		})
+	}
+}
+
+func TestWriteBaseFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	// Plain text files are created with their folder
+	plain := filepath.Join(dir, ".deploy", "prod.sha")
+	assert.NoError(t, WriteBaseFile(plain, "", "abc123"))
+	sha, err := ReadBaseFile(plain, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", sha)
+
+	// JSON documents keep their other fields
+	state := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(state, []byte(`{"staging": {"sha": "old"}, "deployments": [{"sha": "old"}]}`), 0600); err != nil {
+		t.Fatalf("Error writing state file: %v", err)
+	}
+	assert.NoError(t, WriteBaseFile(state, "prod.sha", "def456"))
+	assert.NoError(t, WriteBaseFile(state, "deployments.0.sha", "ghi789"))
+	content, err := os.ReadFile(state)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"staging": {"sha": "old"}, "prod": {"sha": "def456"}, "deployments": [{"sha": "ghi789"}]}`, string(content))
+
+	// Missing JSON documents are created
+	created := filepath.Join(dir, "created.json")
+	assert.NoError(t, WriteBaseFile(created, "prod.sha", "jkl012"))
+	sha, err = ReadBaseFile(created, "prod.sha")
+	assert.NoError(t, err)
+	assert.Equal(t, "jkl012", sha)
+
+	// Array indexes must exist and values cannot be replaced by objects
+	assert.Error(t, WriteBaseFile(state, "deployments.5.sha", "mno345"))
+	assert.Error(t, WriteBaseFile(state, "staging.sha.value", "mno345"))
+}
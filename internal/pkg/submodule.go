@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/google/go-github/v66/github"
+)
+
+const (
+	// gitlinkMode is the mode of a submodule entry in a tree, pointing to a commit of the submodule
+	gitlinkMode = "160000"
+)
+
+// SubmoduleBump describes the change of the commit a submodule points to. The old SHA is empty for an added
+// submodule, the new one for a removed submodule.
+type SubmoduleBump struct {
+	Path   string `json:"path"`
+	OldSHA string `json:"old_sha,omitempty"`
+	NewSHA string `json:"new_sha,omitempty"`
+}
+
+// SubmoduleBumps returns the changes of the submodules, in order. The changes of the files inside the submodules
+// are left out.
+func SubmoduleBumps(changes []FileChange) []SubmoduleBump {
+	bumps := []SubmoduleBump{}
+	for _, change := range changes {
+		if change.OldMode == gitlinkMode || change.NewMode == gitlinkMode {
+			bumps = append(bumps, SubmoduleBump{Path: change.Path, OldSHA: change.OldHash, NewSHA: change.NewHash})
+		}
+	}
+	return bumps
+}
+
+// isSubmoduleBump reports whether the change moves a submodule from one commit to another.
+func isSubmoduleBump(change FileChange) bool {
+	return change.OldMode == gitlinkMode && change.NewMode == gitlinkMode && change.OldHash != "" && change.NewHash != ""
+}
+
+// expandSubmoduleChanges inserts after each submodule bump the changes of the files inside the submodule,
+// listed by compare from the old to the new commit of the submodule. A submodule whose changes cannot be listed
+// is logged and only reported as bumped.
+func expandSubmoduleChanges(changes []FileChange, compare func(bump FileChange) ([]FileChange, error)) []FileChange {
+	var result []FileChange
+	for _, change := range changes {
+		result = append(result, change)
+		if !isSubmoduleBump(change) {
+			continue
+		}
+
+		submoduleChanges, err := compare(change)
+		if err != nil {
+			log.Printf("Warning: could not list the changes in submodule %s between %s and %s, only reporting its bump: %v", change.Path, change.OldHash, change.NewHash, err)
+			continue
+		}
+		result = append(result, prefixFileChanges(change.Path, submoduleChanges)...)
+	}
+	return result
+}
+
+// prefixFileChanges prefixes the paths of the changes inside a submodule with the path of the submodule, and
+// marks them as part of the submodule.
+func prefixFileChanges(submodulePath string, changes []FileChange) []FileChange {
+	prefix := submodulePath + "/"
+	for i := range changes {
+		changes[i].Path = prefix + changes[i].Path
+		if changes[i].PreviousPath != "" {
+			changes[i].PreviousPath = prefix + changes[i].PreviousPath
+		}
+		if changes[i].Submodule == "" {
+			changes[i].Submodule = submodulePath
+		} else {
+			changes[i].Submodule = prefix + changes[i].Submodule
+		}
+		changes[i].Patch = prefixPatchPaths(changes[i].Patch, prefix)
+	}
+	return changes
+}
+
+// prefixPatchPaths prefixes the paths in the headers of the unified diff of a file, leaving the hunks untouched.
+func prefixPatchPaths(patch, prefix string) string {
+	if patch == "" {
+		return ""
+	}
+	// Only the paths of the diff --git line hold " b/"
+	diffReplacer := strings.NewReplacer("diff --git a/", "diff --git a/"+prefix, " b/", " b/"+prefix)
+
+	lines := strings.SplitAfter(patch, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "@@") {
+			break
+		}
+		if strings.HasPrefix(line, "diff --git ") {
+			lines[i] = diffReplacer.Replace(line)
+			continue
+		}
+		for _, header := range []string{"--- a/", "+++ b/", "rename from ", "rename to ", "copy from ", "copy to "} {
+			if strings.HasPrefix(line, header) {
+				lines[i] = header + prefix + strings.TrimPrefix(line, header)
+				break
+			}
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// compareGitFolderSubmodules lists the changes inside the submodules bumped between two commits, from the
+// repositories of the submodules checked out in the worktree.
+func compareGitFolderSubmodules(repoPath string, changes []FileChange, opts CompareOptions) []FileChange {
+	// The patterns match the paths from the root of the repository, so the changes inside are filtered once prefixed
+	opts.IncludePatterns, opts.ExcludePatterns = nil, nil
+	return expandSubmoduleChanges(changes, func(bump FileChange) ([]FileChange, error) {
+		return CompareGitFolderSHAs(filepath.Join(repoPath, bump.Path), bump.OldHash, bump.NewHash, opts)
+	})
+}
+
+// compareGitHubSubmodules lists the changes inside the submodules bumped between two commits, comparing the
+// commits in the repositories of the submodules on GitHub. The repositories are read from the .gitmodules file
+// of the current SHA.
+func compareGitHubSubmodules(ctx context.Context, client *github.Client, cfg *InputConfig, changes []FileChange) []FileChange {
+	var modules *config.Modules
+	return expandSubmoduleChanges(changes, func(bump FileChange) ([]FileChange, error) {
+		if modules == nil {
+			var err error
+			if modules, err = getGitHubModules(ctx, client, cfg); err != nil {
+				return nil, err
+			}
+		}
+
+		submoduleRepo, err := getGitHubSubmoduleRepo(modules, cfg.Repo, bump.Path)
+		if err != nil {
+			return nil, err
+		}
+		submoduleCfg := *cfg
+		submoduleCfg.Repo = submoduleRepo
+		submoduleCfg.Sha = bump.NewHash
+		// The patterns match the paths from the root of the repository, so the changes inside are filtered once prefixed
+		submoduleCfg.IncludesPatterns, submoduleCfg.ExcludesPatterns = nil, nil
+		return CompareGithubSHAs(client, &submoduleCfg, bump.OldHash)
+	})
+}
+
+// getGitHubModules reads the submodules from the .gitmodules file of the current SHA.
+func getGitHubModules(ctx context.Context, client *github.Client, cfg *InputConfig) (*config.Modules, error) {
+	owner, repo := extractOwnerRepo(cfg.Repo)
+	file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, ".gitmodules", &github.RepositoryContentGetOptions{Ref: cfg.Sha})
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving .gitmodules of %s: %v", cfg.Sha, err)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("error decoding .gitmodules of %s: %v", cfg.Sha, err)
+	}
+
+	modules := config.NewModules()
+	if err := modules.Unmarshal([]byte(content)); err != nil {
+		return nil, fmt.Errorf("error parsing .gitmodules of %s: %v", cfg.Sha, err)
+	}
+	return modules, nil
+}
+
+// getGitHubSubmoduleRepo returns the owner/repo of the submodule at the path, from its URL in .gitmodules. A
+// relative URL is resolved against the repository of the superproject.
+func getGitHubSubmoduleRepo(modules *config.Modules, superprojectRepo, submodulePath string) (string, error) {
+	for _, submodule := range modules.Submodules {
+		if submodule.Path != submodulePath {
+			continue
+		}
+
+		url := strings.TrimSuffix(strings.TrimSuffix(submodule.URL, "/"), ".git")
+		if strings.HasPrefix(url, "./") || strings.HasPrefix(url, "../") {
+			url = path.Join(superprojectRepo, url)
+		}
+		// The owner and repository are the last two segments of HTTPS, SSH and SCP-like URLs
+		segments := strings.FieldsFunc(url, func(r rune) bool { return r == '/' || r == ':' })
+		if len(segments) < 2 {
+			return "", fmt.Errorf("could not get the repository of submodule %s from its URL %s", submodulePath, submodule.URL)
+		}
+		return segments[len(segments)-2] + "/" + segments[len(segments)-1], nil
+	}
+	return "", fmt.Errorf("submodule %s is not in .gitmodules", submodulePath)
+}
@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+// addTestGitlinkCommit commits a submodule entry at the top level path pointing to the SHA, on top of the
+// checked out commit of the test repository. Returns the new commit SHA.
+func addTestGitlinkCommit(t *testing.T, repoPath, path, sha string) string {
+	t.Helper()
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("Error opening repository: %v", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("Error getting head: %v", err)
+	}
+	parent, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatalf("Error getting head commit: %v", err)
+	}
+	parentTree, err := parent.Tree()
+	if err != nil {
+		t.Fatalf("Error getting head tree: %v", err)
+	}
+
+	tree := &object.Tree{}
+	for _, entry := range parentTree.Entries {
+		if entry.Name != path {
+			tree.Entries = append(tree.Entries, entry)
+		}
+	}
+	tree.Entries = append(tree.Entries, object.TreeEntry{Name: path, Mode: filemode.Submodule, Hash: plumbing.NewHash(sha)})
+	sort.Slice(tree.Entries, func(i, j int) bool { return tree.Entries[i].Name < tree.Entries[j].Name })
+
+	treeObject := repo.Storer.NewEncodedObject()
+	if err := tree.Encode(treeObject); err != nil {
+		t.Fatalf("Error encoding tree: %v", err)
+	}
+	treeHash, err := repo.Storer.SetEncodedObject(treeObject)
+	if err != nil {
+		t.Fatalf("Error storing tree: %v", err)
+	}
+
+	signature := object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(1700000000+testCommitCount.Add(1)*60, 0)}
+	commit := &object.Commit{Author: signature, Committer: signature, Message: "bump", TreeHash: treeHash, ParentHashes: []plumbing.Hash{parent.Hash}}
+	commitObject := repo.Storer.NewEncodedObject()
+	if err := commit.Encode(commitObject); err != nil {
+		t.Fatalf("Error encoding commit: %v", err)
+	}
+	commitHash, err := repo.Storer.SetEncodedObject(commitObject)
+	if err != nil {
+		t.Fatalf("Error storing commit: %v", err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), commitHash)); err != nil {
+		t.Fatalf("Error updating %s: %v", head.Name(), err)
+	}
+	return commitHash.String()
+}
+
+func TestCompareGitFolderSubmodules(t *testing.T) {
+	t.Parallel()
+	submodulePath, submoduleShas := newTestRepo(t,
+		map[string]string{"main.tf": "a\n", "old.tf": "b\n"},
+		map[string]string{"main.tf": "a\nb\n", "old.tf": "", "vars.tf": "c\n"},
+	)
+	repoPath, _ := newTestRepo(t, map[string]string{"README.md": "readme"})
+	base := addTestGitlinkCommit(t, repoPath, "modules", submoduleShas[0])
+	head := addTestGitlinkCommit(t, repoPath, "modules", submoduleShas[1])
+
+	// Check the submodule out in the worktree
+	if _, err := git.PlainClone(filepath.Join(repoPath, "modules"), false, &git.CloneOptions{URL: submodulePath}); err != nil {
+		t.Fatalf("Error cloning submodule: %v", err)
+	}
+
+	bump := FileChange{Path: "modules", Status: FileModified, OldHash: submoduleShas[0], NewHash: submoduleShas[1], OldMode: gitlinkMode, NewMode: gitlinkMode}
+
+	// Without recursion, only the bump is reported
+	changes, err := CompareGitFolderSHAs(repoPath, base, head, CompareOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, []FileChange{bump}, changes)
+	assert.Equal(t, []SubmoduleBump{{Path: "modules", OldSHA: submoduleShas[0], NewSHA: submoduleShas[1]}}, SubmoduleBumps(changes))
+
+	changes, err = CompareGitFolderSHAs(repoPath, base, head, CompareOptions{RecurseSubmodules: true, Patches: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, []string{"modules", "modules/main.tf", "modules/old.tf", "modules/vars.tf"}, FileChangePaths(changes))
+	for _, change := range changes[1:] {
+		assert.Equal(t, "modules", change.Submodule, "Submodule of %s", change.Path)
+	}
+	assert.Contains(t, changes[1].Patch, "diff --git a/modules/main.tf b/modules/main.tf\n")
+	assert.Contains(t, changes[1].Patch, "--- a/modules/main.tf\n+++ b/modules/main.tf\n")
+	// The bumps inside the submodules are left out
+	assert.Len(t, SubmoduleBumps(changes), 1)
+
+	// A submodule that is not checked out is only reported as bumped
+	otherPath, _ := newTestRepo(t, map[string]string{"README.md": "readme"})
+	otherBase := addTestGitlinkCommit(t, otherPath, "modules", submoduleShas[0])
+	otherHead := addTestGitlinkCommit(t, otherPath, "modules", submoduleShas[1])
+	changes, err = CompareGitFolderSHAs(otherPath, otherBase, otherHead, CompareOptions{RecurseSubmodules: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, []FileChange{bump}, changes)
+}
+
+func TestCompareGitHubSubmodules(t *testing.T) {
+	t.Parallel()
+	client, mux, _ := setup(t)
+
+	// The superproject bumps a submodule with a relative URL and another one without changes to list
+	mux.HandleFunc("/repos/owner/repo/compare/base123...head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"files": [
+			{"filename": "modules", "status": "modified", "sha": "subhead"},
+			{"filename": "vendor/lib", "status": "modified", "sha": "libhead"}
+		]}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/git/trees/base123", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tree": [
+			{"path": "modules", "type": "commit", "mode": "160000", "sha": "subbase"},
+			{"path": "vendor/lib", "type": "commit", "mode": "160000", "sha": "libbase"}
+		]}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/git/trees/head456", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tree": [
+			{"path": "modules", "type": "commit", "mode": "160000", "sha": "subhead"},
+			{"path": "vendor/lib", "type": "commit", "mode": "160000", "sha": "libhead"}
+		]}`)
+	})
+	gitmodules := "[submodule \"modules\"]\n\tpath = modules\n\turl = ../modules.git\n[submodule \"lib\"]\n\tpath = vendor/lib\n\turl = git@github.com:other/lib.git\n"
+	mux.HandleFunc("/repos/owner/repo/contents/.gitmodules", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ref") != "head456" {
+			t.Errorf("Expected .gitmodules of head456, got %s", r.URL.RawQuery)
+		}
+		fmt.Fprintf(w, `{"type": "file", "encoding": "base64", "content": "%s"}`, base64.StdEncoding.EncodeToString([]byte(gitmodules)))
+	})
+
+	// The submodule repository compares its own commits
+	mux.HandleFunc("/repos/owner/modules/compare/subbase...subhead", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"files": [{"filename": "main.tf", "status": "modified", "sha": "blob2", "additions": 1, "changes": 1}]}`)
+	})
+	mux.HandleFunc("/repos/owner/modules/git/trees/subbase", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tree": [{"path": "main.tf", "type": "blob", "mode": "100644", "sha": "blob1"}]}`)
+	})
+	mux.HandleFunc("/repos/owner/modules/git/trees/subhead", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tree": [{"path": "main.tf", "type": "blob", "mode": "100644", "sha": "blob2"}]}`)
+	})
+	mux.HandleFunc("/repos/other/lib/compare/libbase...libhead", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	})
+
+	cfg := &InputConfig{Repo: "owner/repo", Sha: "head456", RecurseSubmodules: "true"}
+	changes, err := CompareGithubSHAs(client, cfg, "base123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assert.Equal(t, []FileChange{
+		{Path: "modules", Status: FileModified, OldHash: "subbase", NewHash: "subhead", OldMode: gitlinkMode, NewMode: gitlinkMode},
+		{Path: "modules/main.tf", Status: FileModified, OldHash: "blob1", NewHash: "blob2", OldMode: "100644", NewMode: "100644", Additions: 1, Changes: 1, Submodule: "modules"},
+		{Path: "vendor/lib", Status: FileModified, OldHash: "libbase", NewHash: "libhead", OldMode: gitlinkMode, NewMode: gitlinkMode},
+	}, changes)
+}
+
+func TestGetGitHubSubmoduleRepo(t *testing.T) {
+	t.Parallel()
+	modules := config.NewModules()
+	gitmodules := `[submodule "https"]
+	path = https
+	url = https://github.com/owner/https.git
+[submodule "ssh"]
+	path = ssh
+	url = ssh://git@github.com/owner/ssh
+[submodule "scp"]
+	path = scp
+	url = git@github.com:owner/scp.git
+[submodule "sibling"]
+	path = sibling
+	url = ../sibling.git
+[submodule "other"]
+	path = other
+	url = ../../other/repo
+`
+	if err := modules.Unmarshal([]byte(gitmodules)); err != nil {
+		t.Fatalf("Error parsing .gitmodules: %v", err)
+	}
+
+	for path, expected := range map[string]string{"https": "owner/https", "ssh": "owner/ssh", "scp": "owner/scp", "sibling": "owner/sibling", "other": "other/repo"} {
+		repo, err := getGitHubSubmoduleRepo(modules, "owner/superproject", path)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", path, err)
+		}
+		assert.Equal(t, expected, repo, "Repository of %s", path)
+	}
+
+	if _, err := getGitHubSubmoduleRepo(modules, "owner/superproject", "unknown"); err == nil {
+		t.Errorf("Expected an error, but got nil")
+	}
+}
+
+func TestPrefixPatchPaths(t *testing.T) {
+	t.Parallel()
+	patch := "diff --git a/old.tf b/new.tf\nrename from old.tf\nrename to new.tf\n--- a/old.tf\n+++ b/new.tf\n@@ -1 +1 @@\n--- a/kept\n+rename to kept\n"
+	expected := "diff --git a/modules/old.tf b/modules/new.tf\nrename from modules/old.tf\nrename to modules/new.tf\n--- a/modules/old.tf\n+++ b/modules/new.tf\n@@ -1 +1 @@\n--- a/kept\n+rename to kept\n"
+	assert.Equal(t, expected, prefixPatchPaths(patch, "modules/"))
+	assert.Equal(t, "", prefixPatchPaths("", "modules/"))
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// semver holds the parsed parts of a semantic version such as v1.2.3-rc.1
+type semver struct {
+	major, minor, patch int
+	prerelease          []string
+}
+
+// parseSemver parses the version at the end of a tag name, after the last "/" and an optional "v" prefix,
+// e.g. "service-a/v1.2.3". Missing minor and patch numbers default to zero and build metadata is ignored.
+// Returns false if the tag does not end with a semantic version.
+func parseSemver(tag string) (semver, bool) {
+	version := tag[strings.LastIndex(tag, "/")+1:]
+	version = strings.TrimPrefix(version, "v")
+	version, _, _ = strings.Cut(version, "+")
+	version, prerelease, hasPrerelease := strings.Cut(version, "-")
+
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return semver{}, false
+	}
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		numbers[i] = n
+	}
+
+	v := semver{major: numbers[0], minor: numbers[1], patch: numbers[2]}
+	if hasPrerelease {
+		if prerelease == "" {
+			return semver{}, false
+		}
+		v.prerelease = strings.Split(prerelease, ".")
+	}
+	return v, true
+}
+
+// isPrerelease reports whether the version has a prerelease suffix
+func (v semver) isPrerelease() bool {
+	return len(v.prerelease) > 0
+}
+
+// compare returns -1, 0 or 1 if v is lower than, equal to or greater than other following
+// the semantic versioning precedence rules.
+func (v semver) compare(other semver) int {
+	for _, c := range []int{v.major - other.major, v.minor - other.minor, v.patch - other.patch} {
+		if c != 0 {
+			return sign(c)
+		}
+	}
+
+	// A version without prerelease has a higher precedence than one with a prerelease
+	switch {
+	case !v.isPrerelease() && !other.isPrerelease():
+		return 0
+	case !v.isPrerelease():
+		return 1
+	case !other.isPrerelease():
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		aNum, aErr := strconv.Atoi(a)
+		bNum, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				return sign(aNum - bNum)
+			}
+		case aErr == nil:
+			// Numeric identifiers have a lower precedence than alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(a, b); c != 0 {
+				return c
+			}
+		}
+	}
+	return sign(len(v.prerelease) - len(other.prerelease))
+}
+
+// sign returns -1, 0 or 1 for a negative, zero or positive n
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// isTagPattern reports whether the tag contains glob characters rather than naming a single tag
+func isTagPattern(tag string) bool {
+	return strings.ContainsAny(tag, "*?[{")
+}
+
+// SortTagsByVersion returns the tags matching the pattern whose name ends with a semantic version,
+// sorted from the highest to the lowest version. Prereleases are left out when skipPrereleases is set.
+func SortTagsByVersion(tags []string, pattern string, skipPrereleases bool) []string {
+	type versionedTag struct {
+		name    string
+		version semver
+	}
+
+	var matched []versionedTag
+	for _, tag := range tags {
+		ok, err := doublestar.Match(pattern, tag)
+		if err != nil {
+			log.Printf("Error matching pattern '%s': %v", pattern, err)
+			return nil
+		}
+		if !ok {
+			continue
+		}
+		version, ok := parseSemver(tag)
+		if !ok || (skipPrereleases && version.isPrerelease()) {
+			continue
+		}
+		matched = append(matched, versionedTag{name: tag, version: version})
+	}
+
+	slices.SortStableFunc(matched, func(a, b versionedTag) int {
+		return b.version.compare(a.version)
+	})
+
+	sorted := make([]string, 0, len(matched))
+	for _, tag := range matched {
+		sorted = append(sorted, tag.name)
+	}
+	return sorted
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSemver(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		tag      string
+		expected semver
+		ok       bool
+	}{
+		{name: "Plain version", tag: "1.2.3", expected: semver{major: 1, minor: 2, patch: 3}, ok: true},
+		{name: "Prefixed version", tag: "v1.2.3", expected: semver{major: 1, minor: 2, patch: 3}, ok: true},
+		{name: "Component version", tag: "service-a/v2.0.1", expected: semver{major: 2, patch: 1}, ok: true},
+		{name: "Short version", tag: "v3", expected: semver{major: 3}, ok: true},
+		{name: "Prerelease", tag: "v1.0.0-rc.1", expected: semver{major: 1, prerelease: []string{"rc", "1"}}, ok: true},
+		{name: "Build metadata", tag: "v1.0.0+build.5", expected: semver{major: 1}, ok: true},
+		{name: "Not a version", tag: "latest", ok: false},
+		{name: "Too many parts", tag: "v1.2.3.4", ok: false},
+		{name: "Empty prerelease", tag: "v1.2.3-", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, ok := parseSemver(tt.tag)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.expected, version)
+			}
+		})
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	t.Parallel()
+	// Ordered from the lowest to the highest precedence
+	ordered := []string{
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.0.1",
+		"v1.2.0",
+		"v2.0.0",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := parseSemver(ordered[i])
+			b, _ := parseSemver(ordered[j])
+			assert.Equal(t, sign(i-j), a.compare(b), "compare(%s, %s)", ordered[i], ordered[j])
+		}
+	}
+}
+
+func TestSortTagsByVersion(t *testing.T) {
+	t.Parallel()
+	tags := []string{"v1.0.0", "v1.10.0", "v1.2.0", "v2.0.0-rc.1", "latest", "service-a/v3.0.0", "service-a/v1.0.0"}
+
+	assert.Equal(t, []string{"v2.0.0-rc.1", "v1.10.0", "v1.2.0", "v1.0.0"}, SortTagsByVersion(tags, "v*", false))
+	assert.Equal(t, []string{"v1.10.0", "v1.2.0", "v1.0.0"}, SortTagsByVersion(tags, "v*", true))
+	assert.Equal(t, []string{"service-a/v3.0.0", "service-a/v1.0.0"}, SortTagsByVersion(tags, "service-a/v*", true))
+	assert.Empty(t, SortTagsByVersion(tags, "service-b/v*", true))
+}